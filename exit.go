@@ -0,0 +1,67 @@
+package main
+
+// exit codes of the tagger, so scripts can react to the kind of failure
+const (
+	// any failure not covered by the codes below
+	exitFailure = 1
+	// invalid arguments or options
+	exitUsage = 2
+	// tokens or registry credentials are not set
+	exitCredentials = 3
+	// the registry could not be queried or updated
+	exitRegistry = 4
+	// some tags were already moved when the release failed
+	exitPartialRetag = 5
+	// the builds repo could not be cloned
+	exitClone = 6
+	// the release was committed but could not be pushed
+	exitPush = 7
+	// the promotion policy or the signature gate refused the release
+	exitPolicy = 8
+)
+
+// taggerError is an error that ends the tagger with a specific exit code
+type taggerError struct {
+	code int
+	err  error
+}
+
+func (e taggerError) Error() string {
+	return e.err.Error()
+}
+
+// withExitCode makes the tagger exit with code on err, unless err already carries a code
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(taggerError); ok {
+		return err
+	}
+
+	return taggerError{code: code, err: err}
+}
+
+// partialRetag makes the tagger exit with exitPartialRetag on err, whatever code err carries,
+// since tags were already moved when it happened
+func partialRetag(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if e, ok := err.(taggerError); ok {
+		err = e.err
+	}
+
+	return taggerError{code: exitPartialRetag, err: err}
+}
+
+// exitCode returns the exit code for err
+func exitCode(err error) int {
+	if e, ok := err.(taggerError); ok {
+		return e.code
+	}
+
+	return exitFailure
+}
@@ -1,26 +1,77 @@
 package git
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type gitCommandClient struct {
 	dir string
+	// stdout receives the output of the git commands
+	stdout io.Writer
 }
 
 var _ RepoClient = &gitCommandClient{}
 
-func newFromCommand(dir string) (*gitCommandClient, error) {
+func newFromCommand(dir string, depth int, stdout io.Writer) (*gitCommandClient, error) {
+	c := &gitCommandClient{dir: dir, stdout: stdout}
+
 	url := "git@github.com:protonet/builds.git"
-	cmd := exec.Command("git", "clone", url, dir)
+	params := []string{"clone", "--branch", "master"}
+	if depth > 0 {
+		params = append(params, "--depth", strconv.Itoa(depth))
+	}
+	cmd := exec.Command("git", append(params, url, dir)...)
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = c.stdout
 
-	return &gitCommandClient{dir: dir}, cmd.Run()
+	return c, cmd.Run()
+}
+
+// openFromCommand brings an existing clone in line with the remote master branch,
+// dropping any leftovers of previous runs
+func openFromCommand(dir string, depth int, stdout io.Writer) (*gitCommandClient, error) {
+	c := &gitCommandClient{dir: dir, stdout: stdout}
+
+	fetchParams := []string{"fetch", "origin", "master"}
+	if depth > 0 {
+		fetchParams = append(fetchParams, "--depth", strconv.Itoa(depth))
+	}
+
+	steps := [][]string{
+		fetchParams,
+		{"checkout", "--force", "master"},
+		{"reset", "--hard", "origin/master"},
+		{"clean", "--force", "-d", "-x"},
+	}
+
+	for _, params := range steps {
+		err := c.command(params...).Run()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// command prepares a git command operating on the client's repository
+func (c *gitCommandClient) command(params ...string) *exec.Cmd {
+	cmd := exec.Command("git", append([]string{"--git-dir", path.Join(c.dir, ".git"), "--work-tree", c.dir}, params...)...)
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = c.stdout
+
+	return cmd
 }
 
 func (c *gitCommandClient) Close() {
@@ -29,24 +80,14 @@ func (c *gitCommandClient) Close() {
 	}
 }
 
-func (c *gitCommandClient) AddAndCommitChannel(channelName, commitMessage string) error {
-	fileName := fmt.Sprintf("%s.json", channelName)
-	addParams := []string{"--git-dir", path.Join(c.dir, ".git"), "--work-tree", c.dir, "add", fileName}
-	addCmd := exec.Command("git", addParams...)
-	addCmd.Stderr = os.Stderr
-	addCmd.Stdin = os.Stdin
-	addCmd.Stdout = os.Stdout
-	err := addCmd.Run()
+func (c *gitCommandClient) AddAndCommitFiles(fileNames []string, commitMessage string) error {
+	addParams := append([]string{"add", "--"}, fileNames...)
+	err := c.command(addParams...).Run()
 	if err != nil {
 		return err
 	}
 
-	commitParams := []string{"--git-dir", path.Join(c.dir, ".git"), "--work-tree", c.dir, "commit", "-m", commitMessage}
-	commitCmd := exec.Command("git", commitParams...)
-	commitCmd.Stderr = os.Stderr
-	commitCmd.Stdin = os.Stdin
-	commitCmd.Stdout = os.Stdout
-	err = commitCmd.Run()
+	err = c.command("commit", "-m", commitMessage).Run()
 	if err != nil {
 		return err
 	}
@@ -55,11 +96,56 @@ func (c *gitCommandClient) AddAndCommitChannel(channelName, commitMessage string
 }
 
 func (c *gitCommandClient) Push() error {
-	params := []string{"--git-dir", path.Join(c.dir, ".git"), "--work-tree", c.dir, "push"}
-	cmd := exec.Command("git", params...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
+	return c.command("push").Run()
+}
+
+func (c *gitCommandClient) ChannelHistory(channelName string) ([]ChannelRevision, error) {
+	fileName := fmt.Sprintf("%s.json", channelName)
+	logCmd := c.command("log", "--diff-filter=AM", "--format=%H%x09%at%x09%an <%ae>", "--", fileName)
+	logCmd.Stdout = nil
+	out, err := logCmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []ChannelRevision
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Unexpected git log output '%s'", scanner.Text())
+		}
+
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		showCmd := c.command("show", fmt.Sprintf("%s:%s", fields[0], fileName))
+		showCmd.Stdout = nil
+		content, err := showCmd.Output()
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, ChannelRevision{
+			CommitID: fields[0],
+			Author:   fields[2],
+			Date:     time.Unix(timestamp, 0).UTC(),
+			Content:  content,
+		})
+	}
+
+	return revisions, scanner.Err()
+}
+
+func (c *gitCommandClient) HeadCommitID() (string, error) {
+	cmd := c.command("rev-parse", "HEAD")
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
 
-	return cmd.Run()
+	return strings.TrimSpace(string(out)), nil
 }
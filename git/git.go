@@ -1,11 +1,15 @@
 package git
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"time"
 )
 
 type BuildsDatum struct {
@@ -14,45 +18,151 @@ type BuildsDatum struct {
 	URL         string            `json:"url"`
 	PublishedAt string            `json:"published_at"`
 	Images      map[string]string `json:"images"`
+	// ImageIDs holds the docker image ids of the images the tags pointed to when the build was released
+	ImageIDs map[string]string `json:"image_ids,omitempty"`
+	// ManifestDigests holds the manifest digests of the released images pushed with the v2 registry API
+	ManifestDigests map[string]string `json:"manifest_digests,omitempty"`
 }
 
 type BuildsData []BuildsDatum
 
+// ChannelRevision is the raw content of a channel file as of a single commit
+type ChannelRevision struct {
+	CommitID string
+	Author   string
+	Date     time.Time
+	Content  []byte
+}
+
 type RepoClient interface {
 	Close()
-	AddAndCommitChannel(channelName, commitMessage string) error
+	// AddAndCommitFiles commits the files, given relative to the repository, in a single commit
+	AddAndCommitFiles(fileNames []string, commitMessage string) error
 	Push() error
+	// ChannelHistory returns every revision of a channel file, newest first
+	ChannelHistory(channelName string) ([]ChannelRevision, error)
+	// HeadCommitID returns the id of the checked out commit
+	HeadCommitID() (string, error)
+}
+
+// ChannelHistoryEntry is a channel's build data as of a single commit
+type ChannelHistoryEntry struct {
+	CommitID string
+	Author   string
+	Date     time.Time
+	Builds   BuildsData
 }
 
 type BuildsRepo struct {
 	directory string
 	client    RepoClient
+	// lock is held on cached directories, which are kept on Close
+	lock *os.File
+	// signingKey signs channels when saving them, publicKey verifies them when loading
+	signingKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// RepoOptions controls how the builds repository is checked out
+type RepoOptions struct {
+	// CacheDir keeps the clone between runs, it gets fetched and reset instead of cloned anew
+	CacheDir string
+	// Depth makes a shallow clone of the given number of commits, 0 clones the whole history
+	Depth int
+	// ChannelSigningKey writes a detached signature next to every saved channel, e.g. stable.json.sig
+	ChannelSigningKey ed25519.PrivateKey
+	// ChannelPublicKey makes loading channels fail unless their signature matches
+	ChannelPublicKey ed25519.PublicKey
+	// GitOutput receives the output the 'command' git client prints, os.Stdout if not set
+	GitOutput io.Writer
 }
 
 func PrepareRepo(gitClient string) (*BuildsRepo, error) {
-	dir, err := ioutil.TempDir("", "tagger")
-	if err != nil {
-		return nil, err
+	return PrepareRepoWithOptions(gitClient, RepoOptions{})
+}
+
+func PrepareRepoWithOptions(gitClient string, opts RepoOptions) (*BuildsRepo, error) {
+	if gitClient != "libgit" && gitClient != "command" {
+		return nil, fmt.Errorf("Unknown git client '%s'", gitClient)
 	}
 
-	var c RepoClient
+	// an existing cache dir is not cloned again, so the depth has to be checked here
+	if gitClient == "libgit" && opts.Depth > 0 {
+		return nil, errorLibgitShallow
+	}
+
+	if opts.GitOutput == nil {
+		opts.GitOutput = os.Stdout
+	}
 
-	switch gitClient {
-	case "libgit":
-		c, err = newFromLibgit(dir)
+	if opts.CacheDir != "" {
+		repo, err := prepareCachedRepo(gitClient, opts)
 		if err != nil {
 			return nil, err
 		}
-	case "command":
-		c, err = newFromCommand(dir)
-		if err != nil {
-			return nil, err
+
+		repo.signingKey, repo.publicKey = opts.ChannelSigningKey, opts.ChannelPublicKey
+		return repo, nil
+	}
+
+	dir, err := ioutil.TempDir("", "tagger")
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cloneRepo(gitClient, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BuildsRepo{directory: dir, client: c, signingKey: opts.ChannelSigningKey, publicKey: opts.ChannelPublicKey}, nil
+}
+
+func prepareCachedRepo(gitClient string, opts RepoOptions) (*BuildsRepo, error) {
+	lock, err := lockDirectory(opts.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to lock cache directory '%s': %s", opts.CacheDir, err.Error())
+	}
+
+	c, err := openOrCloneRepo(gitClient, opts.CacheDir, opts)
+	if err != nil {
+		unlockDirectory(lock)
+		return nil, err
+	}
+
+	return &BuildsRepo{directory: opts.CacheDir, client: c, lock: lock}, nil
+}
+
+func openOrCloneRepo(gitClient, dir string, opts RepoOptions) (RepoClient, error) {
+	_, err := os.Stat(path.Join(dir, ".git"))
+	if err == nil {
+		if gitClient == "libgit" {
+			return openFromLibgit(dir)
 		}
-	default:
-		return nil, fmt.Errorf("Unknown git client '%s'", gitClient)
+		return openFromCommand(dir, opts.Depth, opts.GitOutput)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("Cache directory '%s' is neither empty nor a git repository", dir)
 	}
 
-	return &BuildsRepo{directory: dir, client: c}, nil
+	return cloneRepo(gitClient, dir, opts)
+}
+
+func cloneRepo(gitClient, dir string, opts RepoOptions) (RepoClient, error) {
+	if gitClient == "libgit" {
+		return newFromLibgit(dir, opts.Depth)
+	}
+	return newFromCommand(dir, opts.Depth, opts.GitOutput)
 }
 
 func (br *BuildsRepo) Close() {
@@ -61,6 +171,13 @@ func (br *BuildsRepo) Close() {
 		br.client = nil
 	}
 
+	if br.lock != nil {
+		// cached directories are kept for the next run
+		unlockDirectory(br.lock)
+		br.lock = nil
+		br.directory = ""
+	}
+
 	if br.directory != "" {
 		os.RemoveAll(br.directory)
 		br.directory = ""
@@ -72,13 +189,73 @@ func (br *BuildsRepo) GetDirectory() string {
 }
 
 func (br *BuildsRepo) AddAndCommitChannel(channelName, commitMessage string) error {
-	return br.client.AddAndCommitChannel(channelName, commitMessage)
+	return br.AddAndCommitChannels([]string{channelName}, commitMessage)
+}
+
+// AddAndCommitChannels commits all given channels, along with their signatures and the
+// other files given relative to the repository, in a single commit
+func (br *BuildsRepo) AddAndCommitChannels(channelNames []string, commitMessage string, otherFiles ...string) error {
+	fileNames := append([]string{}, otherFiles...)
+	for _, channelName := range channelNames {
+		fileNames = append(fileNames, fmt.Sprintf("%s.json", channelName))
+		if br.signingKey != nil {
+			fileNames = append(fileNames, fmt.Sprintf("%s.json.sig", channelName))
+		}
+	}
+
+	return br.client.AddAndCommitFiles(fileNames, commitMessage)
+}
+
+// AddAndCommitFiles commits the files, given relative to the repository, in a single commit
+func (br *BuildsRepo) AddAndCommitFiles(fileNames []string, commitMessage string) error {
+	return br.client.AddAndCommitFiles(fileNames, commitMessage)
 }
 
 func (br *BuildsRepo) Push() error {
 	return br.client.Push()
 }
 
+func (br *BuildsRepo) HeadCommitID() (string, error) {
+	return br.client.HeadCommitID()
+}
+
+// IsShallow tells whether the clone lacks older history, like clones made with a depth
+func (br *BuildsRepo) IsShallow() (bool, error) {
+	_, err := os.Stat(path.Join(br.directory, ".git", "shallow"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// ChannelHistory returns the build data of every commit that changed the channel, newest first
+func (br *BuildsRepo) ChannelHistory(channelName string) ([]ChannelHistoryEntry, error) {
+	revisions, err := br.client.ChannelHistory(channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ChannelHistoryEntry
+	for _, rev := range revisions {
+		var builds BuildsData
+
+		err = json.Unmarshal(rev.Content, &builds)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse channel '%s' at commit %s: %s", channelName, rev.CommitID, err.Error())
+		}
+
+		entries = append(entries, ChannelHistoryEntry{
+			CommitID: rev.CommitID,
+			Author:   rev.Author,
+			Date:     rev.Date,
+			Builds:   builds,
+		})
+	}
+
+	return entries, nil
+}
+
 func (br *BuildsRepo) LoadChannel(channelName string) (BuildsData, error) {
 	fileName := fmt.Sprintf("%s.json", channelName)
 	filePath := path.Join(br.directory, fileName)
@@ -90,11 +267,34 @@ func (br *BuildsRepo) LoadChannel(channelName string) (BuildsData, error) {
 		return nil, err
 	}
 
+	if br.publicKey != nil {
+		signature, err := ioutil.ReadFile(filePath + ".sig")
+		if err != nil {
+			// not passed on as is, a missing signature must not look like a missing channel
+			return nil, fmt.Errorf("Failed to read signature of channel '%s': %s", channelName, err.Error())
+		}
+
+		err = verifyChannelData(rawData, signature, br.publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to verify channel '%s': %s", channelName, err.Error())
+		}
+	}
+
+	err = checkFields(rawData)
+	if err != nil {
+		return nil, err
+	}
+
 	err = json.Unmarshal(rawData, &builds)
 	if err != nil {
 		return nil, err
 	}
 
+	err = builds.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	return builds, nil
 }
 
@@ -102,6 +302,11 @@ func (br *BuildsRepo) SaveChannel(channelName string, data BuildsData) error {
 	fileName := fmt.Sprintf("%s.json", channelName)
 	filePath := path.Join(br.directory, fileName)
 
+	err := data.Validate()
+	if err != nil {
+		return err
+	}
+
 	rawData, err := json.MarshalIndent(&data, "", "  ")
 	if err != nil {
 		return err
@@ -112,9 +317,33 @@ func (br *BuildsRepo) SaveChannel(channelName string, data BuildsData) error {
 		return err
 	}
 
+	if br.signingKey != nil {
+		err = ioutil.WriteFile(filePath+".sig", signChannelData(rawData, br.signingKey), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// ListChannels returns the names of all channels in the repository
+func (br *BuildsRepo) ListChannels() ([]string, error) {
+	files, err := ioutil.ReadDir(br.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			channels = append(channels, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+
+	return channels, nil
+}
+
 func (br *BuildsRepo) DumpChannel(channelName string) (string, error) {
 	fileName := fmt.Sprintf("%s.json", channelName)
 	filePath := path.Join(br.directory, fileName)
@@ -6,8 +6,10 @@ import (
 	"math/rand"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/stretchr/testify.v1/assert"
 )
@@ -60,3 +62,171 @@ func TestAddAndCommit(t *testing.T) {
 	_, err = os.Stat(filePath)
 	assert.Nil(t, err, "File '%s' does not exist", filePath)
 }
+
+func TestChannelHistory(t *testing.T) {
+	repo, err := PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	r := make([]byte, 16)
+	_, err = rand.Read(r)
+	assert.Nil(t, err)
+	channelName := fmt.Sprintf("%x", r)
+
+	for _, build := range []int32{1, 2} {
+		err = repo.SaveChannel(channelName, BuildsData{{Build: build, PublishedAt: "2016-09-01T12:00:00Z", Images: map[string]string{}}})
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		err = repo.AddAndCommitChannel(channelName, fmt.Sprintf("build %d", build))
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+	}
+
+	history, err := repo.ChannelHistory(channelName)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, int32(2), history[0].Builds[0].Build)
+	assert.Equal(t, int32(1), history[1].Builds[0].Build)
+	assert.Equal(t, "Platform Tagger <engineering@protonet.info>", history[0].Author)
+	assert.NotEqual(t, history[0].CommitID, history[1].CommitID)
+}
+
+func TestLockDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-lock")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cacheDir := path.Join(dir, "cache")
+	lock, err := lockDirectory(cacheDir)
+	assert.Nil(t, err)
+
+	locked := make(chan bool)
+	go func() {
+		lock2, err := lockDirectory(cacheDir)
+		assert.Nil(t, err)
+		locked <- true
+		unlockDirectory(lock2)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Directory was locked twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlockDirectory(lock)
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Lock was not released")
+	}
+}
+
+func TestPrepareCachedRepoRejectsForeignDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "unrelated.txt"), []byte("foobar"), 0644)
+
+	_, err = PrepareRepoWithOptions("command", RepoOptions{CacheDir: dir})
+	assert.NotNil(t, err)
+
+	_, err = os.Stat(path.Join(dir, "unrelated.txt"))
+	assert.Nil(t, err, "Existing files must not be touched")
+}
+
+func TestPrepareCachedRepoGitOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a clone of a local repository stands in for the builds repo
+	origin := path.Join(dir, "origin")
+	cache := path.Join(dir, "cache")
+	for _, params := range [][]string{
+		{"init", origin},
+		{"-C", origin, "checkout", "-b", "master"},
+		{"-C", origin, "-c", "user.name=tagger", "-c", "user.email=tagger@example.com", "commit", "--allow-empty", "-m", "initial"},
+		{"clone", "--branch", "master", origin, cache},
+	} {
+		out, err := exec.Command("git", params...).CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+
+	var output strings.Builder
+	repo, err := PrepareRepoWithOptions("command", RepoOptions{CacheDir: cache, GitOutput: &output})
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	assert.Contains(t, output.String(), "HEAD is now at")
+}
+
+func TestPrepareCachedRepoRejectsShallowLibgit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(path.Join(dir, ".git"), 0755)
+
+	_, err = PrepareRepoWithOptions("libgit", RepoOptions{CacheDir: dir, Depth: 1})
+	assert.Equal(t, errorLibgitShallow, err)
+
+	_, err = PrepareRepoWithOptions("libgit", RepoOptions{Depth: 1})
+	assert.Equal(t, errorLibgitShallow, err)
+}
+
+func TestSignedChannels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-signed")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	privatePEM, publicPEM, err := GenerateChannelKeys()
+	assert.Nil(t, err)
+	ioutil.WriteFile(path.Join(dir, "channel.key"), privatePEM, 0600)
+	ioutil.WriteFile(path.Join(dir, "channel.pub"), publicPEM, 0644)
+
+	signingKey, err := LoadChannelSigningKey(path.Join(dir, "channel.key"))
+	assert.Nil(t, err)
+	publicKey, err := LoadChannelPublicKey(path.Join(dir, "channel.pub"))
+	assert.Nil(t, err)
+
+	_, err = LoadChannelPublicKey(path.Join(dir, "channel.key"))
+	assert.NotNil(t, err)
+
+	repo := &BuildsRepo{directory: dir, signingKey: signingKey, publicKey: publicKey}
+	builds := BuildsData{{Build: 1, PublishedAt: "2016-09-01T12:00:00Z", Images: map[string]string{"quay.io/experimentalplatform/skvs": "development"}}}
+
+	err = repo.SaveChannel("stable", builds)
+	assert.Nil(t, err)
+	_, err = os.Stat(path.Join(dir, "stable.json.sig"))
+	assert.Nil(t, err)
+
+	loaded, err := repo.LoadChannel("stable")
+	assert.Nil(t, err)
+	assert.Equal(t, builds, loaded)
+
+	// tampered channel
+	raw, _ := ioutil.ReadFile(path.Join(dir, "stable.json"))
+	ioutil.WriteFile(path.Join(dir, "stable.json"), []byte(strings.Replace(string(raw), `"build": 1`, `"build": 2`, 1)), 0644)
+	_, err = repo.LoadChannel("stable")
+	assert.NotNil(t, err)
+
+	// a missing signature must not be taken for a missing channel
+	ioutil.WriteFile(path.Join(dir, "beta.json"), raw, 0644)
+	_, err = repo.LoadChannel("beta")
+	assert.NotNil(t, err)
+	assert.False(t, os.IsNotExist(err))
+
+	// without a public key, signatures are not checked
+	unverified := &BuildsRepo{directory: dir}
+	_, err = unverified.LoadChannel("beta")
+	assert.Nil(t, err)
+
+	channels, err := unverified.ListChannels()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"beta", "stable"}, channels)
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -41,7 +42,14 @@ type libgitClient struct {
 
 var _ RepoClient = &libgitClient{}
 
-func newFromLibgit(dir string) (*libgitClient, error) {
+// errorLibgitShallow is returned when a shallow clone is requested from the libgit client
+var errorLibgitShallow = errors.New("Shallow clones require the 'command' git client")
+
+func newFromLibgit(dir string, depth int) (*libgitClient, error) {
+	if depth > 0 {
+		return nil, errorLibgitShallow
+	}
+
 	RemoteCallbacks := git.RemoteCallbacks{
 		CertificateCheckCallback: certificateCheckCallback,
 		CredentialsCallback:      credentialsCallback,
@@ -62,21 +70,70 @@ func newFromLibgit(dir string) (*libgitClient, error) {
 	return &libgitClient{repo: repo}, nil
 }
 
+// openFromLibgit brings an existing clone in line with the remote master branch,
+// dropping any leftovers of previous runs
+func openFromLibgit(dir string) (*libgitClient, error) {
+	repo, err := git.OpenRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := repo.Remotes.Lookup("origin")
+	if err != nil {
+		return nil, err
+	}
+
+	fetchOptions := &git.FetchOptions{
+		RemoteCallbacks: git.RemoteCallbacks{
+			CertificateCheckCallback: certificateCheckCallback,
+			CredentialsCallback:      credentialsCallback,
+		}}
+	err = remote.Fetch([]string{}, fetchOptions, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := repo.References.Lookup("refs/remotes/origin/master")
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.LookupCommit(ref.Target())
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.SetHead("refs/heads/master")
+	if err != nil {
+		return nil, err
+	}
+
+	checkoutOptions := &git.CheckoutOpts{Strategy: git.CheckoutForce | git.CheckoutRemoveUntracked}
+	err = repo.ResetToCommit(commit, git.ResetHard, checkoutOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &libgitClient{repo: repo}, nil
+}
+
 func (c *libgitClient) Close() {
 	if c.repo != nil {
 		c.repo = nil
 	}
 }
 
-func (c *libgitClient) AddAndCommitChannel(channelName, commitMessage string) error {
+func (c *libgitClient) AddAndCommitFiles(fileNames []string, commitMessage string) error {
 	idx, err := c.repo.Index()
 	if err != nil {
 		return err
 	}
 
-	err = idx.AddByPath(fmt.Sprintf("%s.json", channelName))
-	if err != nil {
-		return err
+	for _, fileName := range fileNames {
+		err = idx.AddByPath(fileName)
+		if err != nil {
+			return err
+		}
 	}
 
 	treeID, err := idx.WriteTree()
@@ -101,7 +158,7 @@ func (c *libgitClient) AddAndCommitChannel(channelName, commitMessage string) er
 
 	commitTarget, err := c.repo.LookupCommit(branch.Target())
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	signature := &git.Signature{
@@ -112,7 +169,7 @@ func (c *libgitClient) AddAndCommitChannel(channelName, commitMessage string) er
 
 	_, err = c.repo.CreateCommit("refs/heads/master", signature, signature, commitMessage, tree, commitTarget)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	return nil
@@ -133,3 +190,83 @@ func (c *libgitClient) Push() error {
 
 	return err
 }
+
+func (c *libgitClient) ChannelHistory(channelName string) ([]ChannelRevision, error) {
+	fileName := fmt.Sprintf("%s.json", channelName)
+
+	walk, err := c.repo.Walk()
+	if err != nil {
+		return nil, err
+	}
+
+	err = walk.PushHead()
+	if err != nil {
+		return nil, err
+	}
+	walk.Sorting(git.SortTime)
+
+	var (
+		revisions []ChannelRevision
+		walkErr   error
+	)
+
+	err = walk.Iterate(func(commit *git.Commit) bool {
+		entry := treeEntryByPath(commit, fileName)
+		if entry == nil {
+			// file does not exist in this commit
+			return true
+		}
+
+		// only keep commits which actually changed the file
+		if commit.ParentCount() > 0 {
+			parentEntry := treeEntryByPath(commit.Parent(0), fileName)
+			if parentEntry != nil && parentEntry.Id.Equal(entry.Id) {
+				return true
+			}
+		}
+
+		blob, err := c.repo.LookupBlob(entry.Id)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+
+		author := commit.Author()
+		revisions = append(revisions, ChannelRevision{
+			CommitID: commit.Id().String(),
+			Author:   fmt.Sprintf("%s <%s>", author.Name, author.Email),
+			Date:     author.When,
+			Content:  blob.Contents(),
+		})
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, walkErr
+}
+
+func treeEntryByPath(commit *git.Commit, fileName string) *git.TreeEntry {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil
+	}
+
+	entry, err := tree.EntryByPath(fileName)
+	if err != nil {
+		return nil
+	}
+
+	return entry
+}
+
+func (c *libgitClient) HeadCommitID() (string, error) {
+	head, err := c.repo.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return head.Target().String(), nil
+}
@@ -0,0 +1,35 @@
+package git
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockDirectory takes an exclusive lock on a directory by locking a sibling
+// '<dir>.lock' file, waiting as long as another process holds it
+func lockDirectory(dir string) (*os.File, error) {
+	lockFile, err := os.OpenFile(filepath.Clean(dir)+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		log.Printf("Directory '%s' is in use by another process, waiting for it to finish", dir)
+		err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX)
+	}
+
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+
+	return lockFile, nil
+}
+
+func unlockDirectory(lockFile *os.File) {
+	syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	lockFile.Close()
+}
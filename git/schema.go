@@ -0,0 +1,203 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of JSON Schema (draft 4) needed to describe channel files
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *int64             `json:"minimum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	PatternProperties    map[string]*Schema `json:"patternProperties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// BuildsSchema describes the format of channel files, generated from BuildsDatum
+func BuildsSchema() *Schema {
+	minItems := 1
+
+	return &Schema{
+		Schema:   "http://json-schema.org/draft-04/schema#",
+		Title:    "Release channel",
+		Type:     "array",
+		MinItems: &minItems,
+		Items:    typeSchema(reflect.TypeOf(BuildsDatum{})),
+	}
+}
+
+func typeSchema(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{
+			Type:                 "object",
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: false,
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+			name := tag[0]
+
+			s.Properties[name] = typeSchema(t.Field(i).Type)
+			constrainField(name, s.Properties[name])
+
+			if len(tag) == 1 || tag[1] != "omitempty" {
+				s.Required = append(s.Required, name)
+			}
+		}
+
+		return s
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	}
+
+	panic(fmt.Sprintf("no JSON schema for type %s", t))
+}
+
+// constrainField adds the rules of BuildsData.Validate to a field's schema
+func constrainField(name string, s *Schema) {
+	switch name {
+	case "build":
+		minimum := int64(1)
+		s.Minimum = &minimum
+	case "published_at":
+		s.Format = "date-time"
+	case "images":
+		s.PatternProperties = map[string]*Schema{
+			imageNameRegexp.String(): {Type: "string", Pattern: tagRegexp.String()},
+		}
+		s.AdditionalProperties = false
+	case "image_ids":
+		s.PatternProperties = map[string]*Schema{
+			imageNameRegexp.String(): {Type: "string", Pattern: imageIDRegexp.String()},
+		}
+		s.AdditionalProperties = false
+	case "manifest_digests":
+		s.PatternProperties = map[string]*Schema{
+			imageNameRegexp.String(): {Type: "string", Pattern: manifestDigestRegexp.String()},
+		}
+		s.AdditionalProperties = false
+	}
+}
+
+// ValidateJSON checks raw JSON against the schema and returns all violations
+func (s *Schema) ValidateJSON(rawData []byte) ([]string, error) {
+	var value interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(string(rawData)))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.validate(value, "$"), nil
+}
+
+func (s *Schema) validate(value interface{}, location string) []string {
+	var problems []string
+
+	switch s.Type {
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array", location)}
+		}
+		if s.MinItems != nil && len(items) < *s.MinItems {
+			problems = append(problems, fmt.Sprintf("%s: expected at least %d items", location, *s.MinItems))
+		}
+		for i, item := range items {
+			problems = append(problems, s.Items.validate(item, fmt.Sprintf("%s[%d]", location, i))...)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object", location)}
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing property '%s'", location, name))
+			}
+		}
+
+		var keys []string
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			problems = append(problems, s.validateProperty(key, object[key], location)...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected a string", location)}
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			problems = append(problems, fmt.Sprintf("%s: '%s' does not match '%s'", location, str, s.Pattern))
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: '%s' is not a date-time", location, str))
+			}
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an integer", location)}
+		}
+		integer, err := number.Int64()
+		if err != nil {
+			return []string{fmt.Sprintf("%s: expected an integer", location)}
+		}
+		if s.Minimum != nil && integer < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: %d is less than %d", location, integer, *s.Minimum))
+		}
+	}
+
+	return problems
+}
+
+func (s *Schema) validateProperty(key string, value interface{}, location string) []string {
+	propertyLocation := fmt.Sprintf("%s.%s", location, key)
+
+	if property, ok := s.Properties[key]; ok {
+		return property.validate(value, propertyLocation)
+	}
+
+	for pattern, property := range s.PatternProperties {
+		if regexp.MustCompile(pattern).MatchString(key) {
+			return property.validate(value, propertyLocation)
+		}
+	}
+
+	switch additional := s.AdditionalProperties.(type) {
+	case *Schema:
+		return additional.validate(value, propertyLocation)
+	case bool:
+		if !additional {
+			return []string{fmt.Sprintf("%s: property '%s' is not allowed", location, key)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// GenerateChannelKeys returns a new PEM encoded ed25519 key pair for signing channels
+func GenerateChannelKeys() (privatePEM, publicPEM []byte, err error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	return privatePEM, publicPEM, nil
+}
+
+func readPEM(keyPath, blockType string) ([]byte, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key '%s': %s", keyPath, err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("File '%s' does not contain a PEM encoded %s", keyPath, blockType)
+	}
+
+	return block.Bytes, nil
+}
+
+// LoadChannelSigningKey reads an ed25519 private key as written by GenerateChannelKeys
+func LoadChannelSigningKey(keyPath string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(keyPath, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse key '%s': %s", keyPath, err.Error())
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Key '%s' is not an ed25519 key", keyPath)
+	}
+
+	return privateKey, nil
+}
+
+// LoadChannelPublicKey reads an ed25519 public key as written by GenerateChannelKeys
+func LoadChannelPublicKey(keyPath string) (ed25519.PublicKey, error) {
+	der, err := readPEM(keyPath, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse key '%s': %s", keyPath, err.Error())
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Key '%s' is not an ed25519 key", keyPath)
+	}
+
+	return publicKey, nil
+}
+
+// signChannelData returns the detached signature of a channel file, base64 encoded on a single line
+func signChannelData(rawData []byte, key ed25519.PrivateKey) []byte {
+	signature := ed25519.Sign(key, rawData)
+	return []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+}
+
+func verifyChannelData(rawData, signature []byte, key ed25519.PublicKey) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("Malformed signature: %s", err.Error())
+	}
+
+	if !ed25519.Verify(key, rawData, decoded) {
+		return errors.New("Signature does not match")
+	}
+
+	return nil
+}
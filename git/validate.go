@@ -0,0 +1,145 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// imageNameRegexp matches image references like 'quay.io/experimentalplatform/skvs'
+	imageNameRegexp = regexp.MustCompile(`^[a-z0-9]+([.-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)+$`)
+	// tagRegexp matches the tags accepted by docker registries
+	tagRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	// imageIDRegexp matches Quay's docker image ids
+	imageIDRegexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+	// manifestDigestRegexp matches manifest digests
+	manifestDigestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ValidTag reports whether a tag is accepted by docker registries
+func ValidTag(tag string) bool {
+	return tagRegexp.MatchString(tag)
+}
+
+// ValidationError lists all problems found in a channel's build data
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// knownFields returns the JSON keys of BuildsDatum
+func knownFields() map[string]bool {
+	fields := make(map[string]bool)
+
+	t := reflect.TypeOf(BuildsDatum{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		fields[name] = true
+	}
+
+	return fields
+}
+
+// checkFields makes sure that raw channel JSON contains no keys unknown to BuildsDatum
+func checkFields(rawData []byte) error {
+	var entries []map[string]json.RawMessage
+
+	err := json.Unmarshal(rawData, &entries)
+	if err != nil {
+		return err
+	}
+
+	known := knownFields()
+	var problems []string
+	for i, entry := range entries {
+		var keys []string
+		for key := range entry {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !known[key] {
+				problems = append(problems, fmt.Sprintf("build #%d: unknown field '%s'", i, key))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// Validate checks the build data for everything devices rely on
+func (data BuildsData) Validate() error {
+	if len(data) == 0 {
+		return &ValidationError{Problems: []string{"channel contains no builds"}}
+	}
+
+	var problems []string
+	for i, datum := range data {
+		if datum.Build <= 0 {
+			problems = append(problems, fmt.Sprintf("build #%d: build number must be positive, got %d", i, datum.Build))
+		}
+
+		_, err := time.Parse(time.RFC3339, datum.PublishedAt)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("build #%d: published_at '%s' is not an RFC3339 timestamp", i, datum.PublishedAt))
+		}
+
+		var images []string
+		for image := range datum.Images {
+			images = append(images, image)
+		}
+		sort.Strings(images)
+
+		for _, image := range images {
+			if !imageNameRegexp.MatchString(image) {
+				problems = append(problems, fmt.Sprintf("build #%d: malformed image name '%s'", i, image))
+			}
+			if tag := datum.Images[image]; !tagRegexp.MatchString(tag) {
+				problems = append(problems, fmt.Sprintf("build #%d: malformed tag '%s' for image '%s'", i, tag, image))
+			}
+		}
+
+		problems = append(problems, validateDigests(i, datum.Images, datum.ImageIDs, imageIDRegexp, "image id")...)
+		problems = append(problems, validateDigests(i, datum.Images, datum.ManifestDigests, manifestDigestRegexp, "manifest digest")...)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// validateDigests checks that the digests belong to images of the build and match the regexp
+func validateDigests(build int, images, digests map[string]string, digestRegexp *regexp.Regexp, kind string) []string {
+	var digestImages []string
+	for image := range digests {
+		digestImages = append(digestImages, image)
+	}
+	sort.Strings(digestImages)
+
+	var problems []string
+	for _, image := range digestImages {
+		if _, ok := images[image]; !ok {
+			problems = append(problems, fmt.Sprintf("build #%d: %s for unknown image '%s'", build, kind, image))
+		}
+		if digest := digests[image]; !digestRegexp.MatchString(digest) {
+			problems = append(problems, fmt.Sprintf("build #%d: malformed %s '%s' for image '%s'", build, kind, digest, image))
+		}
+	}
+
+	return problems
+}
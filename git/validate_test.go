@@ -0,0 +1,109 @@
+package git
+
+import (
+	"testing"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func validDatum() BuildsDatum {
+	return BuildsDatum{
+		Build:       12,
+		Codename:    "Development Alpha",
+		URL:         "https://www.example.com/",
+		PublishedAt: "2016-08-24T14:02:38Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs":   "2016-08-24-1402",
+			"quay.io/protonetinc/german-shepherd": "2016-08-24-1402",
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, BuildsData{validDatum()}.Validate())
+
+	empty := BuildsData{}
+	assert.NotNil(t, empty.Validate())
+
+	noBuild := validDatum()
+	noBuild.Build = 0
+	assert.NotNil(t, BuildsData{noBuild}.Validate())
+
+	badTimestamp := validDatum()
+	badTimestamp.PublishedAt = "2016-08-24 14:02"
+	assert.NotNil(t, BuildsData{badTimestamp}.Validate())
+
+	badImage := validDatum()
+	badImage.Images["quay.io/Experimental Platform/skvs"] = "2016-08-24-1402"
+	assert.NotNil(t, BuildsData{badImage}.Validate())
+
+	badTag := validDatum()
+	badTag.Images["quay.io/experimentalplatform/skvs"] = "tag with spaces"
+	err := BuildsData{badTag}.Validate()
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Len(t, err.(*ValidationError).Problems, 1)
+}
+
+func TestCheckFields(t *testing.T) {
+	assert.Nil(t, checkFields([]byte(`[{"build": 1, "codename": "", "url": "", "published_at": "", "images": {}}]`)))
+
+	err := checkFields([]byte(`[{"build": 1, "images": {}, "image": {}}]`))
+	assert.NotNil(t, err)
+	assert.Equal(t, "build #0: unknown field 'image'", err.Error())
+}
+
+func TestSchemaValidateJSON(t *testing.T) {
+	schema := BuildsSchema()
+
+	problems, err := schema.ValidateJSON([]byte(`[{"build": 1, "codename": "", "url": "", "published_at": "2016-08-24T14:02:38Z", "images": {"quay.io/experimentalplatform/skvs": "2016-08-24-1402"}}]`))
+	assert.Nil(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = schema.ValidateJSON([]byte(`[]`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"$: expected at least 1 items"}, problems)
+
+	problems, err = schema.ValidateJSON([]byte(`[{"build": 0, "codename": "", "published_at": "yesterday", "images": {"quay.io/experimentalplatform/skvs": "a b"}, "foo": 1}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"$[0]: missing property 'url'",
+		"$[0].build: 0 is less than 1",
+		"$[0]: property 'foo' is not allowed",
+		"$[0].images.quay.io/experimentalplatform/skvs: 'a b' does not match '^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$'",
+		"$[0].published_at: 'yesterday' is not a date-time",
+	}, problems)
+
+	_, err = schema.ValidateJSON([]byte(`[{`))
+	assert.NotNil(t, err)
+}
+
+func TestValidateDigests(t *testing.T) {
+	datum := validDatum()
+	datum.ImageIDs = map[string]string{
+		"quay.io/experimentalplatform/skvs": "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f",
+	}
+	assert.Nil(t, BuildsData{datum}.Validate())
+
+	datum.ImageIDs["quay.io/experimentalplatform/unknown"] = "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f"
+	datum.ImageIDs["quay.io/experimentalplatform/skvs"] = "latest"
+	err := BuildsData{datum}.Validate()
+	assert.NotNil(t, err)
+	assert.Len(t, err.(*ValidationError).Problems, 2)
+
+	datum = validDatum()
+	datum.ManifestDigests = map[string]string{
+		"quay.io/experimentalplatform/skvs": "sha256:8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f",
+	}
+	assert.Nil(t, BuildsData{datum}.Validate())
+
+	datum.ManifestDigests["quay.io/experimentalplatform/skvs"] = "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f"
+	err = BuildsData{datum}.Validate()
+	assert.NotNil(t, err, "manifest digests name their algorithm")
+
+	// manifest digests have their own field
+	datum = validDatum()
+	datum.ImageIDs = map[string]string{
+		"quay.io/experimentalplatform/skvs": "sha256:8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f",
+	}
+	assert.NotNil(t, BuildsData{datum}.Validate())
+}
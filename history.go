@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+type historyLine struct {
+	CommitID    string    `json:"commit"`
+	Author      string    `json:"author"`
+	Date        time.Time `json:"date"`
+	Build       int32     `json:"build"`
+	PublishedAt string    `json:"published_at"`
+	Codename    string    `json:"codename"`
+}
+
+func channelHistory(repo *git.BuildsRepo, channelName string) ([]historyLine, error) {
+	entries, err := repo.ChannelHistory(channelName)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []historyLine{}
+	for _, entry := range entries {
+		line := historyLine{
+			CommitID: entry.CommitID,
+			Author:   entry.Author,
+			Date:     entry.Date,
+		}
+
+		if len(entry.Builds) > 0 {
+			line.Build = entry.Builds[0].Build
+			line.PublishedAt = entry.Builds[0].PublishedAt
+			line.Codename = entry.Builds[0].Codename
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+func printHistory(repo *git.BuildsRepo, opts taggerOptions) error {
+	lines, err := channelHistory(repo, opts.Args.SourceChannel)
+	if err != nil {
+		return fmt.Errorf("Failed to read history of channel '%s': %s", opts.Args.SourceChannel, err.Error())
+	}
+
+	if opts.Output == "json" {
+		return printJSON(lines)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BUILD\tPUBLISHED AT\tCODENAME\tCOMMIT\tAUTHOR")
+	for _, line := range lines {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", line.Build, line.PublishedAt, line.Codename, line.CommitID, line.Author)
+	}
+
+	return w.Flush()
+}
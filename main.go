@@ -1,26 +1,39 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/experimental-platform/release-tagger/git"
 	"github.com/jessevdk/go-flags"
 )
 
-func checkIfTokensPresent() {
+func checkIfTokensPresent() error {
 	if len(os.Getenv("TOKEN_PLATFORM")) == 0 {
-		log.Fatal("TOKEN_PLATFORM is not set")
+		return withExitCode(exitCredentials, errors.New("TOKEN_PLATFORM is not set"))
 	}
 
 	if len(os.Getenv("TOKEN_PROTONET")) == 0 {
-		log.Fatal("TOKEN_PROTONET is not set")
+		return withExitCode(exitCredentials, errors.New("TOKEN_PROTONET is not set"))
 	}
+
+	return nil
 }
 
 func updateJSON(repo *git.BuildsRepo, opts taggerOptions, tagTimestamp, isoTimestamp string) error {
+	return updateChannels(repo, opts, tagTimestamp, isoTimestamp, newReleaseResult(opts, tagTimestamp, isoTimestamp))
+}
+
+// updateChannels releases the source channel to the target channels and records the outcome in result
+func updateChannels(repo *git.BuildsRepo, opts taggerOptions, tagTimestamp, isoTimestamp string, result *releaseResult) error {
 	var (
 		Retag bool
 	)
@@ -32,148 +45,1030 @@ func updateJSON(repo *git.BuildsRepo, opts taggerOptions, tagTimestamp, isoTimes
 		Retag = true
 		break
 	default:
-		fmt.Fprintf(os.Stderr, "The only allowed actions are 'copy' and 'create'\n")
-		os.Exit(1)
-		break
+		return withExitCode(exitUsage, errors.New("The only allowed actions are 'copy' and 'create'"))
+	}
+
+	if opts.TargetRegistry != "" && !Retag {
+		return withExitCode(exitUsage, errors.New("Copying images to another registry requires the 'create' action"))
+	}
+	// copy keeps the tags of the source channel, so these would be ignored silently
+	for _, option := range []struct {
+		name  string
+		given bool
+	}{
+		{"--tag-template", opts.TagTemplate != ""},
+		{"--skip-unchanged", opts.SkipUnchanged},
+		{"--signature-key", opts.SignatureKey != ""},
+		{"--signing-key", opts.SigningKey != ""},
+	} {
+		if option.given && !Retag {
+			return withExitCode(exitUsage, fmt.Errorf("Option %s requires the 'create' action", option.name))
+		}
+	}
+	if opts.TargetRegistry != "" && opts.SkipUnchanged {
+		return withExitCode(exitUsage, errors.New("Images copied to another registry cannot be compared with --skip-unchanged"))
+	}
+
+	if opts.ReleaseNotes != "" {
+		err := checkReleaseNotesDir(opts.ReleaseNotes)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
+	}
+
+	targetChannels := opts.Args.targetChannels()
+	seen := map[string]bool{opts.Args.SourceChannel: true}
+	for _, channel := range targetChannels {
+		if seen[channel] {
+			return withExitCode(exitUsage, fmt.Errorf("Channel '%s' given more than once", channel))
+		}
+		seen[channel] = true
 	}
 
 	oldBuilds, err := repo.LoadChannel(opts.Args.SourceChannel)
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to load build data from channel '%s': %s", opts.Args.SourceChannel, err.Error())
 	}
 
-	newBuilds := []git.BuildsDatum{oldBuilds[0]}
-	if opts.Build != 0 {
-		// if build number was given on commandline then set to it
-		newBuilds[0].Build = opts.Build
-	} else {
-		destBuilds, err2 := repo.LoadChannel(opts.Args.TargetChannel)
-		if err2 != nil {
-			// if targetchannel doesn't exist, set to #1
-			newBuilds[0].Build = 1
-		} else {
-			// otherwise increment
-			newBuilds[0].Build = destBuilds[0].Build + 1
+	var overrides []string
+	if opts.Policy != "" {
+		overrides, err = checkPolicy(opts, oldBuilds[0], isoTimestamp)
+		if err != nil {
+			return withExitCode(exitPolicy, err)
 		}
 	}
-	newBuilds[0].PublishedAt = isoTimestamp
-	if opts.URL != "" {
-		newBuilds[0].URL = opts.URL
+	result.SourceBuild = oldBuilds[0].Build
+	result.Overrides = overrides
+
+	selected, err := selectImages(oldBuilds[0].Images, opts.Only, opts.Exclude)
+	if err != nil {
+		return withExitCode(exitUsage, err)
 	}
-	if opts.Codename != "" {
-		newBuilds[0].Codename = opts.Codename
+
+	destChannels := make(map[string]git.BuildsData)
+	for _, targetChannel := range targetChannels {
+		destBuilds, err := repo.LoadChannel(targetChannel)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to load build data from channel '%s': %s", targetChannel, err.Error())
+		}
+		destChannels[targetChannel] = destBuilds
 	}
 
-	if Retag {
-		for k := range newBuilds[0].Images {
-			newBuilds[0].Images[k] = tagTimestamp
+	// images whose target channel tag already points to the source image keep that tag
+	unchanged := make(map[string]map[string]imageRef)
+	if Retag && opts.SkipUnchanged {
+		err = checkIfTokensPresent()
+		if err != nil {
+			return err
+		}
+
+		unchanged, err = findUnchangedImages(selected, opts.Args.SourceChannel, destChannels)
+		if err != nil {
+			return withExitCode(exitRegistry, err)
 		}
 	}
 
-	log.Printf("Old build version: %d", oldBuilds[0].Build)
-	log.Printf("New build version: %d", newBuilds[0].Build)
+	// signatures are checked before any image is retagged
+	var verified map[string]string
+	if Retag && opts.SignatureKey != "" {
+		key, err := loadVerificationKey(opts.SignatureKey)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
 
-	err = repo.SaveChannel(opts.Args.TargetChannel, newBuilds)
-	if err != nil {
-		return fmt.Errorf("Failed to save channel json: %s", err.Error())
+		err = checkIfTokensPresent()
+		if err != nil {
+			return err
+		}
+
+		sourceTags := make(map[string]string)
+		for image := range selected {
+			sourceTags[image] = opts.Args.SourceChannel
+		}
+
+		verified, err = checkSignatures(sourceTags, key)
+		if err != nil {
+			return withExitCode(exitRegistry, err)
+		}
 	}
 
-	if opts.Commit == true {
-		commitMessage := fmt.Sprintf("release on channel '%s' at %s", opts.Args.TargetChannel, isoTimestamp)
-		err := repo.AddAndCommitChannel(opts.Args.TargetChannel, commitMessage)
+	var signingKey *ecdsa.PrivateKey
+	if Retag && opts.SigningKey != "" {
+		signingKey, err = loadSigningKey(opts.SigningKey)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
+
+		err = checkIfTokensPresent()
 		if err != nil {
 			return err
 		}
 
-		err = repo.Push()
+		// v1 images cannot be signed, which must be found before any tag is moved
+		toSign := make(map[string]string)
+		for image := range selected {
+			for _, targetChannel := range targetChannels {
+				if _, ok := unchanged[targetChannel][image]; !ok {
+					toSign[image] = opts.Args.SourceChannel
+				}
+			}
+		}
+
+		err = checkSignable(toSign)
 		if err != nil {
 			return err
 		}
+	}
+
+	// every target channel gets its own build number and tag
+	buildNumbers := make(map[string]int32)
+	newTags := make(map[string]string)
+	for _, targetChannel := range targetChannels {
+		buildNumbers[targetChannel] = newBuildNumber(opts.Build, destChannels[targetChannel])
+
+		if Retag {
+			newTags[targetChannel], err = releaseTag(repo, opts.TagTemplate, targetChannel, buildNumbers[targetChannel], tagTimestamp, isoTimestamp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// once a tag is moved, every failure up to the commit leaves the release half done
+	moved := false
+	failed := func(err error) error {
+		if moved {
+			return partialRetag(err)
+		}
+		return err
+	}
+
+	// target channels sharing the same new tag are retagged together, so every tag is created only once
+	imageIDs := make(map[string]map[string]imageRef)
+	for _, targetChannel := range targetChannels {
+		tag := newTags[targetChannel]
+		if _, done := imageIDs[tag]; !Retag || done {
+			continue
+		}
+
+		tagImages := make(map[string]string)
+		for _, channel := range targetChannels {
+			if newTags[channel] != tag {
+				continue
+			}
+
+			for image, sourceTag := range selected {
+				if _, ok := unchanged[channel][image]; !ok {
+					tagImages[image] = sourceTag
+				}
+			}
+		}
+
+		imageIDs[tag] = nil
+		if len(tagImages) > 0 {
+			imageIDs[tag], err = retaggingStep(tagImages, &opts, tag, verified, signingKey, result)
+			if err != nil {
+				return failed(err)
+			}
+			moved = moved || imageIDs[tag] != nil
+		}
+	}
+
+	// build the data for every target channel before writing anything,
+	// so that either all channels are updated or none is
+	newChannels := make(map[string]git.BuildsData)
+	allNotes := make(map[string]releaseNotes)
+	for _, targetChannel := range targetChannels {
+		newBuilds := []git.BuildsDatum{copyDatum(oldBuilds[0])}
+		destBuilds := destChannels[targetChannel]
+
+		newBuilds[0].Build = buildNumbers[targetChannel]
+		newBuilds[0].PublishedAt = isoTimestamp
+		if opts.URL != "" {
+			newBuilds[0].URL = opts.URL
+		}
+		if opts.Codename != "" {
+			newBuilds[0].Codename = opts.Codename
+		}
+
+		// when filtering images, the target channel keeps its tags for all other images
+		var targetBuild *git.BuildsDatum
+		if (len(opts.Only) > 0 || len(opts.Exclude) > 0) && destBuilds != nil {
+			targetBuild = &destBuilds[0]
+		}
+
+		if Retag {
+			promoted := make(map[string]string)
+			for image, tag := range selected {
+				if _, ok := unchanged[targetChannel][image]; !ok {
+					promoted[image] = tag
+				}
+			}
+
+			promoteImages(&newBuilds[0], oldBuilds[0], targetBuild, promoted, newTags[targetChannel], imageIDs[newTags[targetChannel]])
+			if opts.TargetRegistry != "" {
+				mirrorImageNames(&newBuilds[0], promoted, opts.TargetRegistry)
+			}
+
+			for image, id := range unchanged[targetChannel] {
+				log.Printf("Image '%s' is unchanged in channel '%s', keeping tag '%s'", image, targetChannel, destBuilds[0].Images[image])
+				result.Images = append(result.Images, imageResult{
+					Image:   image,
+					Tag:     destBuilds[0].Images[image],
+					ID:      id.String(),
+					Status:  imageKept,
+					Channel: targetChannel,
+				})
+				newBuilds[0].Images[image] = destBuilds[0].Images[image]
+				recordImageRef(&newBuilds[0], image, id)
+			}
+		} else {
+			promoteImages(&newBuilds[0], oldBuilds[0], targetBuild, selected, "", recordedImageRefs(oldBuilds[0]))
+		}
+
+		if opts.ReleaseNotes != "" {
+			var previous *git.BuildsDatum
+			if destBuilds != nil {
+				previous = &destBuilds[0]
+			}
+
+			allNotes[targetChannel] = buildReleaseNotes(targetChannel, opts.Args.SourceChannel, previous, newBuilds[0])
+			if opts.URL == "" {
+				newBuilds[0].URL = releaseNotesURL(opts.ReleaseNotesURL, opts.ReleaseNotes, allNotes[targetChannel])
+			}
+		}
+
+		log.Printf("Channel '%s':", targetChannel)
+		log.Printf("Old build version: %d", oldBuilds[0].Build)
+		log.Printf("New build version: %d", newBuilds[0].Build)
+
+		newChannels[targetChannel] = newBuilds
+
+		channelResult := channelResult{
+			Channel: targetChannel,
+			Build:   newBuilds[0].Build,
+			Tag:     newTags[targetChannel],
+			Data:    newBuilds[0],
+		}
+		if destBuilds != nil {
+			channelResult.PreviousBuild = destBuilds[0].Build
+		}
+		if notes, ok := allNotes[targetChannel]; ok {
+			channelResult.ReleaseNotes = releaseNotesFiles(opts.ReleaseNotes, notes)
+		}
+		result.Channels = append(result.Channels, channelResult)
+	}
+
+	for _, targetChannel := range targetChannels {
+		err = repo.SaveChannel(targetChannel, newChannels[targetChannel])
+		if err != nil {
+			return failed(fmt.Errorf("Failed to save channel json: %s", err.Error()))
+		}
+	}
+
+	var notesFiles []string
+	for _, targetChannel := range targetChannels {
+		notes, ok := allNotes[targetChannel]
+		if !ok {
+			continue
+		}
+
+		files, err := writeReleaseNotes(repo, opts.ReleaseNotes, notes)
+		if err != nil {
+			return failed(err)
+		}
+		notesFiles = append(notesFiles, files...)
+	}
+
+	if opts.Commit == true {
+		err := repo.AddAndCommitChannels(targetChannels, releaseCommitMessage(targetChannels, isoTimestamp, overrides), notesFiles...)
+		if err != nil {
+			return failed(err)
+		}
+
+		result.CommitID, err = repo.HeadCommitID()
+		if err != nil {
+			return failed(err)
+		}
+
+		err = repo.Push()
+		if err != nil {
+			return withExitCode(exitPush, err)
+		}
+		result.Pushed = true
 		log.Println("Push successful")
 	} else {
-		dump, _ := repo.DumpChannel(opts.Args.TargetChannel)
-		log.Printf("New JSON:\n%s\n", dump)
+		for _, targetChannel := range targetChannels {
+			dump, _ := repo.DumpChannel(targetChannel)
+			log.Printf("New JSON for channel '%s':\n%s\n", targetChannel, dump)
+
+			if notes, ok := allNotes[targetChannel]; ok {
+				log.Printf("Release notes for channel '%s':\n%s\n", targetChannel, notes.markdown())
+			}
+		}
 	}
 
 	return nil
 }
 
+// checkPolicy enforces the promotion policy for all target channels and returns
+// the soak time violations overridden by --force
+func checkPolicy(opts taggerOptions, sourceBuild git.BuildsDatum, isoTimestamp string) ([]string, error) {
+	policy, err := loadPolicy(opts.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseTime, err := time.Parse(time.RFC3339, isoTimestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides []string
+	for _, targetChannel := range opts.Args.targetChannels() {
+		err = policy.check(opts.Args.SourceChannel, targetChannel, opts.ApprovedBy)
+		if err != nil {
+			return nil, err
+		}
+
+		minSoak := policy.requiredSoak(opts.Args.SourceChannel, targetChannel)
+		err = checkSoak(opts.Args.SourceChannel, sourceBuild, targetChannel, releaseTime, minSoak)
+		if _, ok := err.(*soakError); ok && opts.Force {
+			log.Printf("Forced release: %s", err.Error())
+			overrides = append(overrides, err.Error())
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return overrides, nil
+}
+
+func newBuildNumber(build int32, destBuilds git.BuildsData) int32 {
+	if build != 0 {
+		// if build number was given on commandline then set to it
+		return build
+	} else if destBuilds == nil {
+		// if targetchannel doesn't exist, set to #1
+		return 1
+	}
+
+	// otherwise increment
+	return destBuilds[0].Build + 1
+}
+
+// tagTemplateData is available in --tag-template
+type tagTemplateData struct {
+	Channel   string
+	Build     int32
+	Timestamp string
+	Time      time.Time
+
+	repo *git.BuildsRepo
+}
+
+// ShortSHA returns the abbreviated head commit of the builds repo, it is only looked up
+// when the template uses it
+func (d tagTemplateData) ShortSHA() (string, error) {
+	if d.repo == nil {
+		return "", errors.New("There is no builds repo to take the commit from")
+	}
+
+	commitID, err := d.repo.HeadCommitID()
+	if err != nil {
+		return "", err
+	}
+
+	return commitID[:7], nil
+}
+
+// releaseTag renders the tag for a target channel, or returns tagTimestamp if there is no template
+func releaseTag(repo *git.BuildsRepo, tagTemplate, channel string, build int32, tagTimestamp, isoTimestamp string) (string, error) {
+	if tagTemplate == "" {
+		return tagTimestamp, nil
+	}
+
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(tagTemplate)
+	if err != nil {
+		return "", withExitCode(exitUsage, fmt.Errorf("Invalid tag template: %s", err.Error()))
+	}
+
+	data := tagTemplateData{
+		Channel:   channel,
+		Build:     build,
+		Timestamp: tagTimestamp,
+		repo:      repo,
+	}
+
+	data.Time, err = time.Parse(time.RFC3339, isoTimestamp)
+	if err != nil {
+		return "", err
+	}
+
+	var tag bytes.Buffer
+	err = tmpl.Execute(&tag, data)
+	if err != nil {
+		return "", withExitCode(exitUsage, fmt.Errorf("Invalid tag template: %s", err.Error()))
+	}
+
+	if !git.ValidTag(tag.String()) {
+		return "", withExitCode(exitUsage, fmt.Errorf("Tag template produced the invalid tag '%s'", tag.String()))
+	}
+
+	return tag.String(), nil
+}
+
+// findUnchangedImages returns per target channel the images whose current tag in that
+// channel points to the same image as the source tag, along with the image ids
+func findUnchangedImages(images map[string]string, sourceTag string, destChannels map[string]git.BuildsData) (map[string]map[string]imageRef, error) {
+	sourceTags := make(map[string]string)
+	for image := range images {
+		sourceTags[image] = sourceTag
+	}
+
+	sourceIDs, err := lookupImageIDs(sourceTags)
+	if err != nil {
+		return nil, err
+	}
+
+	unchanged := make(map[string]map[string]imageRef)
+	for targetChannel, destBuilds := range destChannels {
+		unchanged[targetChannel] = make(map[string]imageRef)
+		if destBuilds == nil {
+			continue
+		}
+
+		targetTags := make(map[string]string)
+		for image := range images {
+			if tag, ok := destBuilds[0].Images[image]; ok {
+				targetTags[image] = tag
+			}
+		}
+
+		targetIDs, err := lookupImageIDs(targetTags)
+		if err != nil {
+			return nil, err
+		}
+
+		for image, id := range targetIDs {
+			if sourceID, ok := sourceIDs[image]; ok && sourceID.same(id) {
+				unchanged[targetChannel][image] = id
+			}
+		}
+	}
+
+	return unchanged, nil
+}
+
+// selectImages returns the images matching any of the 'only' globs, or all images if there are none,
+// and none of the 'exclude' globs
+func selectImages(images map[string]string, only, exclude []string) (map[string]string, error) {
+	for _, pattern := range append(only, exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid image pattern '%s'", pattern)
+		}
+	}
+
+	selected := make(map[string]string)
+	for image, tag := range images {
+		if (len(only) == 0 || matchesAny(image, only)) && !matchesAny(image, exclude) {
+			selected[image] = tag
+		}
+	}
+
+	if len(selected) == 0 && len(images) > 0 {
+		return nil, errors.New("No images match the given --only and --exclude patterns")
+	}
+
+	return selected, nil
+}
+
+func matchesAny(image string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, image); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// promoteImages sets the images of next to the selected images of the source build merged into
+// the target build. The selected images get newTag, or keep their source tag if newTag is empty,
+// and the given ids. Without a target build, next only gets the selected images.
+func promoteImages(next *git.BuildsDatum, source git.BuildsDatum, target *git.BuildsDatum, selected map[string]string, newTag string, imageIDs map[string]imageRef) {
+	next.Images = make(map[string]string)
+	next.ImageIDs = nil
+	next.ManifestDigests = nil
+
+	if target != nil {
+		for image, tag := range target.Images {
+			next.Images[image] = tag
+		}
+		for image, id := range recordedImageRefs(*target) {
+			recordImageRef(next, image, id)
+		}
+	}
+
+	for image := range selected {
+		next.Images[image] = source.Images[image]
+		if newTag != "" {
+			next.Images[image] = newTag
+		}
+
+		recordImageRef(next, image, imageIDs[image])
+	}
+}
+
+// recordedImageRefs returns the ids recorded for the images of a build
+func recordedImageRefs(datum git.BuildsDatum) map[string]imageRef {
+	refs := make(map[string]imageRef)
+	for image, id := range datum.ImageIDs {
+		refs[image] = imageRef{DockerImageID: id}
+	}
+
+	for image, digest := range datum.ManifestDigests {
+		ref := refs[image]
+		ref.ManifestDigest = digest
+		refs[image] = ref
+	}
+
+	return refs
+}
+
+// recordImageRef replaces the ids recorded for an image, the maps are only created when needed
+func recordImageRef(datum *git.BuildsDatum, image string, ref imageRef) {
+	delete(datum.ImageIDs, image)
+	delete(datum.ManifestDigests, image)
+
+	if ref.DockerImageID != "" {
+		if datum.ImageIDs == nil {
+			datum.ImageIDs = make(map[string]string)
+		}
+		datum.ImageIDs[image] = ref.DockerImageID
+	}
+
+	if ref.ManifestDigest != "" {
+		if datum.ManifestDigests == nil {
+			datum.ManifestDigests = make(map[string]string)
+		}
+		datum.ManifestDigests[image] = ref.ManifestDigest
+	}
+
+	if len(datum.ImageIDs) == 0 {
+		datum.ImageIDs = nil
+	}
+	if len(datum.ManifestDigests) == 0 {
+		datum.ManifestDigests = nil
+	}
+}
+
+// copyDatum returns a copy of the datum which does not share its maps
+func copyDatum(datum git.BuildsDatum) git.BuildsDatum {
+	datumCopy := datum
+	datumCopy.Images = copyMap(datum.Images)
+	datumCopy.ImageIDs = copyMap(datum.ImageIDs)
+	datumCopy.ManifestDigests = copyMap(datum.ManifestDigests)
+
+	return datumCopy
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	mapCopy := make(map[string]string)
+	for k, v := range m {
+		mapCopy[k] = v
+	}
+
+	return mapCopy
+}
+
+// releaseCommitMessage describes the release, listing the policy checks overridden by --force
+func releaseCommitMessage(channels []string, isoTimestamp string, overrides []string) string {
+	var message string
+
+	if len(channels) == 1 {
+		message = fmt.Sprintf("release on channel '%s' at %s", channels[0], isoTimestamp)
+	} else {
+		quoted := make([]string, len(channels))
+		for i, channel := range channels {
+			quoted[i] = fmt.Sprintf("'%s'", channel)
+		}
+
+		message = fmt.Sprintf("release on channels %s at %s", strings.Join(quoted, ", "), isoTimestamp)
+	}
+
+	if len(overrides) > 0 {
+		message += "\n\nForced despite:\n"
+		for _, override := range overrides {
+			message += fmt.Sprintf("* %s\n", override)
+		}
+	}
+
+	return message
+}
+
 type taggerOptionsArgs struct {
-	Action        string `description:"either 'copy' or 'create'"`
+	Action        string `description:"One of 'copy', 'create', 'history', 'validate', 'schema', 'verify', 'prune', 'tag-history', 'snapshot' or 'keygen'"`
 	SourceChannel string `description:"Release channel to be creating/copying from."`
 	TargetChannel string `description:"Release channel to be creating/copying to."`
+
+	MoreTargetChannels []string `description:"Further release channels to be updated in the same commit."`
+}
+
+// targetChannels returns all channels to be released to, in the order given on the commandline
+func (a taggerOptionsArgs) targetChannels() []string {
+	return append([]string{a.TargetChannel}, a.MoreTargetChannels...)
 }
 
 type taggerOptions struct {
-	Args taggerOptionsArgs `positional-args:"true" required:"true"`
+	Args taggerOptionsArgs `positional-args:"true"`
+
+	Commit          bool     `short:"c" long:"commit" description:"Commit the changes, or delete the tags when pruning. Will make a dry run without this flag."`
+	Build           int32    `short:"b" long:"build" required:"false" default:"0" description:"Specify the build number to be placed inside the JSON."`
+	URL             string   `short:"u" long:"url" description:"Release notes URL"`
+	Codename        string   `short:"n" long:"codename" description:"Release codename"`
+	ReleaseNotes    string   `long:"release-notes" description:"Directory in the builds repo to write release notes listing the changed images of every target channel to, as Markdown and JSON. They are committed along with the channels and linked from the url field unless --url is given."`
+	ReleaseNotesURL string   `long:"release-notes-url" default:"https://github.com/protonet/builds/blob/master" description:"Address of the builds repo's files the release notes links start with"`
+	GitClient       string   `long:"git-client" default:"libgit" description:"Git client. Either 'libgit' or 'command'"`
+	Policy          string   `long:"policy" description:"JSON file with the allowed promotions between channels"`
+	Only            []string `long:"only" description:"Only release images matching this glob, e.g. 'quay.io/experimentalplatform/*'. May be given multiple times. The target channels keep their tags for all other images."`
+	Exclude         []string `long:"exclude" description:"Do not release images matching this glob. May be given multiple times. The target channels keep their tags for these images."`
+	Timestamp       string   `long:"timestamp" description:"Release at this RFC3339 time instead of now, e.g. to reproduce a release"`
+	TagTemplate     string   `long:"tag-template" description:"Go template for the new tags instead of the timestamp, e.g. '{{.Channel}}-{{.Build}}-{{.Timestamp}}' or '1.{{.Build}}.0'. Available are .Channel, .Build, .Timestamp, .Time and .ShortSHA of the builds repo."`
+	TargetRegistry  string   `long:"target-registry" description:"Copy the released images to this registry and list them under their new names in the target channels, e.g. 'registry.example.com' or 'registry.example.com/customer' to also replace the organisation. Credentials are taken from MIRROR_USERNAME and MIRROR_PASSWORD."`
+	SignatureKey    string   `long:"signature-key" description:"PEM file with an ECDSA public key. Only images with a cosign signature by this key on their manifest digest are released."`
+	SigningKey      string   `long:"signing-key" description:"PEM file with an unencrypted ECDSA private key. The released images are signed with it and the cosign signatures pushed next to them."`
+	SkipUnchanged   bool     `long:"skip-unchanged" description:"Keep the target channel's tag for images it already ships in the source channel's version instead of creating a new tag."`
+	Force           bool     `long:"force" description:"Release even if the source build has not been published for the minimum soak time of the policy. Recorded in the commit message."`
+	ApprovedBy      []string `long:"approved-by" description:"Name of a person who approved the release, may be given multiple times"`
+	KeepHistory     int      `long:"keep-history" default:"10" description:"Prune keeps the tags of this many past releases of every channel"`
+	KeepNewest      int      `long:"keep-newest" default:"20" description:"Prune keeps this many of the newest tags of every image"`
+	ChannelKey      string   `long:"channel-key" description:"PEM file with an ed25519 private key made by the keygen action. Every written channel gets a detached signature next to it, e.g. stable.json.sig"`
+	ChannelPubKey   string   `long:"channel-public-key" description:"PEM file with an ed25519 public key made by the keygen action. Channels without a matching signature are refused."`
+	CacheDir        string   `long:"cache-dir" description:"Keep the builds repo clone in this directory and update it on later runs instead of cloning again"`
+	Depth           int      `long:"depth" default:"0" description:"Make a shallow clone of the builds repo with the given number of commits. Requires the 'command' git client"`
+	At              string   `long:"at" description:"Point in time, e.g. '2016-08-10T12:00Z'. tag-history shows only the image the tag pointed to then, snapshot shows the channel as it was then instead of now"`
+	Output          string   `long:"output" default:"text" description:"Output format of all actions. Either 'text' or 'json', which prints a single result document to stdout"`
+}
+
+type taggerAction struct {
+	usage   string
+	minArgs int
+	maxArgs int // -1 if unlimited
+}
+
+// taggerActions lists the allowed actions along with their channel arguments
+var taggerActions = map[string]taggerAction{
+	"copy":        {usage: "copy <source channel> <target channel>...", minArgs: 2, maxArgs: -1},
+	"create":      {usage: "create <source channel> <target channel>...", minArgs: 2, maxArgs: -1},
+	"history":     {usage: "history <channel>", minArgs: 1, maxArgs: 1},
+	"validate":    {usage: "validate", minArgs: 0, maxArgs: 0},
+	"schema":      {usage: "schema [<channel>...]", minArgs: 0, maxArgs: -1},
+	"verify":      {usage: "verify <channel>", minArgs: 1, maxArgs: 1},
+	"prune":       {usage: "prune", minArgs: 0, maxArgs: 0},
+	"tag-history": {usage: "tag-history <image> <tag>", minArgs: 2, maxArgs: 2},
+	"snapshot":    {usage: "snapshot <channel>", minArgs: 1, maxArgs: 1},
+	"keygen":      {usage: "keygen <key name>", minArgs: 1, maxArgs: 1},
+}
+
+// operands returns the non-empty positional arguments following the action
+func (a taggerOptionsArgs) operands() []string {
+	var operands []string
+	for _, arg := range append([]string{a.SourceChannel, a.TargetChannel}, a.MoreTargetChannels...) {
+		if arg != "" {
+			operands = append(operands, arg)
+		}
+	}
 
-	Commit    bool   `short:"c" long:"commit" description:"Commit the changes. Will make a dry run without this flag."`
-	Build     int32  `short:"b" long:"build" required:"false" default:"0" description:"Specify the build number to be placed inside the JSON."`
-	URL       string `short:"u" long:"url" description:"Release notes URL"`
-	Codename  string `short:"n" long:"codename" description:"Release codename"`
-	GitClient string `long:"git-client" default:"libgit" description:"Git client. Either 'libgit' or 'command'"`
+	return operands
 }
 
-func retaggingStep(images map[string]string, opts *taggerOptions, tagTimestamp string) {
+func checkArgs(opts *taggerOptions) error {
+	action, ok := taggerActions[opts.Args.Action]
+	if !ok {
+		return fmt.Errorf("Unknown action '%s'", opts.Args.Action)
+	}
+
+	count := len(opts.Args.operands())
+	if count < action.minArgs || (action.maxArgs != -1 && count > action.maxArgs) {
+		return fmt.Errorf("Usage: %s", action.usage)
+	}
+
+	if opts.Output != "text" && opts.Output != "json" {
+		return fmt.Errorf("Unknown output format '%s'", opts.Output)
+	}
+
+	// both only take effect when a policy is checked
+	if opts.Policy == "" && opts.Force {
+		return errors.New("--force requires --policy")
+	}
+	if opts.Policy == "" && len(opts.ApprovedBy) > 0 {
+		return errors.New("--approved-by requires --policy")
+	}
+
+	return nil
+}
+
+// retaggingStep returns the ids of the retagged images, or nil on a dry run. If verified is given,
+// the retagged images must be the ones whose signatures were verified. If signingKey is given,
+// the retagged images are signed with it.
+func retaggingStep(images map[string]string, opts *taggerOptions, tagTimestamp string, verified map[string]string, signingKey *ecdsa.PrivateKey, result *releaseResult) (map[string]imageRef, error) {
 	if opts.Commit == true {
 
-		checkIfTokensPresent()
+		err := checkIfTokensPresent()
+		if err != nil {
+			return nil, err
+		}
+		if opts.TargetRegistry != "" {
+			err = checkIfMirrorCredentialsPresent()
+			if err != nil {
+				return nil, err
+			}
+		}
 
-		err := retagAll(images, opts.Args.SourceChannel, tagTimestamp)
+		err = checkTagCollisions(images, tagTimestamp)
 		if err != nil {
-			log.Fatal(err)
+			return nil, withExitCode(exitRegistry, err)
+		}
+
+		imageIDs, err := retagAll(images, opts.Args.SourceChannel, verified, tagTimestamp)
+		result.addRetagResults(images, tagTimestamp, imageIDs, err)
+		if err != nil {
+			// some of the images already got the new tag
+			if len(imageIDs) > 0 {
+				return nil, partialRetag(err)
+			}
+			return nil, withExitCode(exitRegistry, err)
+		}
+
+		// from here on, all images got the new tag
+		retaggedIDs := imageIDs
+		if opts.TargetRegistry != "" {
+			imageIDs, err = mirrorAll(images, imageIDs, tagTimestamp, opts.TargetRegistry)
+			if err != nil {
+				return nil, partialRetag(err)
+			}
+		}
+
+		if signingKey != nil {
+			err = signAll(retaggedIDs, signingKey, opts.TargetRegistry)
+			if err != nil {
+				return nil, partialRetag(err)
+			}
 		}
 
+		return imageIDs, nil
+
 	} else {
-		log.Printf("Dry run. Would otherwise create following tags from '%s' to '%s' and update channel '%s':\n", opts.Args.SourceChannel, tagTimestamp, opts.Args.TargetChannel)
+		result.addRetagResults(images, tagTimestamp, nil, nil)
+
+		log.Printf("Dry run. Would otherwise create following tags from '%s' to '%s':\n", opts.Args.SourceChannel, tagTimestamp)
 		for k := range images {
 			log.Printf(" * %s\n", k)
 		}
+
+		if opts.TargetRegistry != "" {
+			log.Printf("and copy them to registry '%s' as:\n", opts.TargetRegistry)
+			for k := range images {
+				log.Printf(" * %s\n", mirrorImageName(k, opts.TargetRegistry))
+			}
+		}
+
+		if signingKey != nil {
+			log.Printf("and sign them with key '%s'\n", opts.SigningKey)
+		}
 	}
+
+	return nil, nil
 }
 
-func parseOptions(opts *taggerOptions) {
+// parseOptions prints the problem and the help itself and returns a usage error on invalid options
+func parseOptions(opts *taggerOptions) error {
 	parser := flags.NewParser(opts, flags.Default)
 	_, err := parser.Parse()
 
+	// stdout only holds the JSON document with --output json
+	help := os.Stdout
+	if opts.Output == "json" {
+		help = os.Stderr
+	}
+
 	if err != nil {
 		// this condition prevents the help from being printed twice when specifically requested by the -h|--help parameter
 		if flagserr, ok := err.(*flags.Error); !ok || flagserr.Type != flags.ErrHelp {
-			parser.WriteHelp(os.Stdout)
+			parser.WriteHelp(help)
 		}
-		os.Exit(1)
+		return withExitCode(exitUsage, err)
+	}
+
+	err = checkArgs(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		parser.WriteHelp(help)
+		return withExitCode(exitUsage, err)
 	}
+
+	return nil
 }
 
-func main() {
-	var opts taggerOptions
+// now is the clock used for release timestamps unless --timestamp is given
+var now = time.Now
 
-	parseOptions(&opts)
+// releaseTimestamps returns the tag and ISO timestamps of a release made at --timestamp, or now
+func releaseTimestamps(opts taggerOptions) (string, string, error) {
+	currentTime := now()
 
-	currentTime := time.Now().UTC()
-	tagTimestamp := currentTime.Format("2006-01-02-1504")
-	isoTimestamp := currentTime.Format("2006-01-02T15:04:05Z")
-	fmt.Printf("Tag timestamp: %s\n", tagTimestamp)
-	fmt.Printf("ISO timestamp: %s\n", isoTimestamp)
+	if opts.Timestamp != "" {
+		var err error
+		currentTime, err = time.Parse(time.RFC3339, opts.Timestamp)
+		if err != nil {
+			return "", "", fmt.Errorf("Invalid timestamp '%s': %s", opts.Timestamp, err.Error())
+		}
+	}
+
+	currentTime = currentTime.UTC()
+	return currentTime.Format("2006-01-02-1504"), currentTime.Format("2006-01-02T15:04:05Z"), nil
+}
 
-	repo, err := git.PrepareRepo(opts.GitClient)
+// generateChannelKeys writes a new key pair for signing channels to <name>.key and <name>.pub
+func generateChannelKeys(name string, output string) error {
+	privatePEM, publicPEM, err := git.GenerateChannelKeys()
 	if err != nil {
-		log.Fatalf("Failed to clone the builds repo: %s", err.Error())
+		return err
 	}
-	defer repo.Close()
 
-	builds, err := repo.LoadChannel(opts.Args.SourceChannel)
+	for _, key := range []struct {
+		fileName string
+		data     []byte
+		mode     os.FileMode
+	}{{name + ".key", privatePEM, 0600}, {name + ".pub", publicPEM, 0644}} {
+		// existing keys are never overwritten
+		file, err := os.OpenFile(key.fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, key.mode)
+		if err != nil {
+			return fmt.Errorf("Failed to create key file: %s", err.Error())
+		}
+
+		_, err = file.Write(key.data)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("Failed to write key file '%s': %s", key.fileName, err.Error())
+		}
+	}
+
+	if output == "json" {
+		return printJSON(map[string]string{"private_key": name + ".key", "public_key": name + ".pub"})
+	}
+
+	fmt.Printf("Private key: %s.key\n", name)
+	fmt.Printf("Public key: %s.pub\n", name)
+	return nil
+}
+
+// channelKeyOptions loads the keys for signing and verifying channels
+func channelKeyOptions(opts taggerOptions) (git.RepoOptions, error) {
+	var repoOptions git.RepoOptions
+	var err error
+
+	if opts.ChannelKey != "" {
+		repoOptions.ChannelSigningKey, err = git.LoadChannelSigningKey(opts.ChannelKey)
+		if err != nil {
+			return repoOptions, err
+		}
+	}
+
+	if opts.ChannelPubKey != "" {
+		repoOptions.ChannelPublicKey, err = git.LoadChannelPublicKey(opts.ChannelPubKey)
+		if err != nil {
+			return repoOptions, err
+		}
+	}
+
+	return repoOptions, nil
+}
+
+// release copies or creates the channels, filling result instead of printing the timestamps if given
+func release(repo *git.BuildsRepo, opts taggerOptions, result *releaseResult) error {
+	tagTimestamp, isoTimestamp, err := releaseTimestamps(opts)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+
+	if result == nil {
+		fmt.Printf("Tag timestamp: %s\n", tagTimestamp)
+		fmt.Printf("ISO timestamp: %s\n", isoTimestamp)
+		return updateJSON(repo, opts, tagTimestamp, isoTimestamp)
+	}
+
+	result.TagTimestamp, result.ISOTimestamp = tagTimestamp, isoTimestamp
+	return updateChannels(repo, opts, tagTimestamp, isoTimestamp, result)
+}
+
+// run performs the action and returns the errors main exits with. The copy and create
+// actions fill result if given.
+func run(opts taggerOptions, result *releaseResult) error {
+	// printing the schema does not need the builds repo
+	if opts.Args.Action == "schema" && len(opts.Args.operands()) == 0 {
+		return printSchema()
+	}
+
+	if opts.Args.Action == "keygen" {
+		return generateChannelKeys(opts.Args.SourceChannel, opts.Output)
+	}
+
+	// the tag history comes from Quay only
+	if opts.Args.Action == "tag-history" {
+		return printTagHistory(opts)
+	}
+
+	repoOptions, err := channelKeyOptions(opts)
 	if err != nil {
-		log.Fatalf("Failed to load build data from channel '%s'", opts.Args.SourceChannel)
+		return withExitCode(exitUsage, err)
+	}
+	repoOptions.CacheDir = opts.CacheDir
+	repoOptions.Depth = opts.Depth
+	if opts.Output == "json" {
+		// the output of git must not end up in the JSON document
+		repoOptions.GitOutput = os.Stderr
+	}
+
+	repo, err := git.PrepareRepoWithOptions(opts.GitClient, repoOptions)
+	if err != nil {
+		return withExitCode(exitClone, fmt.Errorf("Failed to clone the builds repo: %s", err.Error()))
+	}
+	defer repo.Close()
+
+	switch opts.Args.Action {
+	case "history":
+		err = printHistory(repo, opts)
+	case "validate":
+		err = validateChannels(repo, opts)
+	case "schema":
+		err = validateChannelsAgainstSchema(repo, opts)
+	case "verify":
+		err = printVerification(repo, opts)
+	case "prune":
+		err = prune(repo, opts)
+	case "snapshot":
+		err = printSnapshot(repo, opts)
+	default:
+		err = release(repo, opts, result)
+	}
+
+	return err
+}
+
+// printsReleaseResult tells whether the action reports its outcome in a result document
+func printsReleaseResult(opts taggerOptions) bool {
+	return (opts.Args.Action == "copy" || opts.Args.Action == "create") && opts.Output == "json"
+}
+
+// execute runs the action unless the options are invalid. With --output json, copy and create
+// print their result document even when they fail before anything is released, all other
+// actions print an error document when they fail before printing their own.
+func execute(opts taggerOptions, optionsErr error) error {
+	documentPrinted = false
+
+	var result *releaseResult
+	if printsReleaseResult(opts) {
+		result = newReleaseResult(opts, "", "")
+	}
+
+	err := optionsErr
+	if err == nil {
+		err = run(opts, result)
+		if err != nil {
+			log.Print(err)
+		}
 	}
 
-	// skip this step if merely copying a channel over
-	if opts.Args.Action == "create" {
-		retaggingStep(builds[0].Images, &opts, tagTimestamp)
+	if result != nil {
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		printErr := result.print()
+		if err == nil {
+			err = printErr
+		}
+	} else if err != nil && opts.Output == "json" {
+		printErrorJSON(opts.Args.Action, err)
 	}
 
-	err = updateJSON(repo, opts, tagTimestamp, isoTimestamp)
+	return err
+}
+
+func main() {
+	var opts taggerOptions
+
+	// invalid options are reported by parseOptions
+	err := parseOptions(&opts)
+
+	err = execute(opts, err)
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/experimental-platform/release-tagger/git"
 
@@ -73,8 +80,8 @@ func TestRenamedImages(t *testing.T) {
 			TargetChannel: "tgt",
 		},
 	}
-	tagTimestamp := "tag-timestamp #124124"
-	isoTimestamp := "wtf_timestamp %3215123"
+	tagTimestamp := "2016-09-01-1200"
+	isoTimestamp := "2016-09-01T12:00:00Z"
 	err = updateJSON(repo, opts, tagTimestamp, isoTimestamp)
 	assert.Nil(t, err)
 
@@ -83,39 +90,39 @@ func TestRenamedImages(t *testing.T) {
     "build": 1,
     "codename": "Development Alpha",
     "url": "foobar",
-    "published_at": "wtf_timestamp %3215123",
+    "published_at": "2016-09-01T12:00:00Z",
     "images": {
-      "quay.io/experimentalplatform/afpd": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/app-manager": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/central-gateway": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/collectd": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/configure": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/dnsmasq": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/dokku": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/elasticsearch": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/frontend": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/haproxy": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hardware": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hostapd": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hostname-avahi": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hostname-smb": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/http-proxy": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/ldap": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/monitoring": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/mysql": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/ptw": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/pulseaudio": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/rabbitmq": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/redis": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/skvs": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/smb": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/systemd-proxy": "tag-timestamp #124124",
-      "quay.io/protonetinc/german-shepherd": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-backup": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-nginx": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-owner": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-protosync": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-smb": "tag-timestamp #124124"
+      "quay.io/experimentalplatform/afpd": "2016-09-01-1200",
+      "quay.io/experimentalplatform/app-manager": "2016-09-01-1200",
+      "quay.io/experimentalplatform/central-gateway": "2016-09-01-1200",
+      "quay.io/experimentalplatform/collectd": "2016-09-01-1200",
+      "quay.io/experimentalplatform/configure": "2016-09-01-1200",
+      "quay.io/experimentalplatform/dnsmasq": "2016-09-01-1200",
+      "quay.io/experimentalplatform/dokku": "2016-09-01-1200",
+      "quay.io/experimentalplatform/elasticsearch": "2016-09-01-1200",
+      "quay.io/experimentalplatform/frontend": "2016-09-01-1200",
+      "quay.io/experimentalplatform/haproxy": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hardware": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hostapd": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hostname-avahi": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hostname-smb": "2016-09-01-1200",
+      "quay.io/experimentalplatform/http-proxy": "2016-09-01-1200",
+      "quay.io/experimentalplatform/ldap": "2016-09-01-1200",
+      "quay.io/experimentalplatform/monitoring": "2016-09-01-1200",
+      "quay.io/experimentalplatform/mysql": "2016-09-01-1200",
+      "quay.io/experimentalplatform/ptw": "2016-09-01-1200",
+      "quay.io/experimentalplatform/pulseaudio": "2016-09-01-1200",
+      "quay.io/experimentalplatform/rabbitmq": "2016-09-01-1200",
+      "quay.io/experimentalplatform/redis": "2016-09-01-1200",
+      "quay.io/experimentalplatform/skvs": "2016-09-01-1200",
+      "quay.io/experimentalplatform/smb": "2016-09-01-1200",
+      "quay.io/experimentalplatform/systemd-proxy": "2016-09-01-1200",
+      "quay.io/protonetinc/german-shepherd": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-backup": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-nginx": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-owner": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-protosync": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-smb": "2016-09-01-1200"
     }
   }
 ]`
@@ -145,8 +152,8 @@ func TestRenamedImages2(t *testing.T) {
 			Action:        "create",
 		},
 	}
-	tagTimestamp := "tag-timestamp #124124"
-	isoTimestamp := "wtf_timestamp %3215123"
+	tagTimestamp := "2016-09-01-1200"
+	isoTimestamp := "2016-09-01T12:00:00Z"
 	err = updateJSON(repo, opts, tagTimestamp, isoTimestamp)
 	assert.Nil(t, err)
 
@@ -155,39 +162,39 @@ func TestRenamedImages2(t *testing.T) {
     "build": 9875,
     "codename": "Zeitgeist",
     "url": "https://www.example.com/",
-    "published_at": "wtf_timestamp %3215123",
+    "published_at": "2016-09-01T12:00:00Z",
     "images": {
-      "quay.io/experimentalplatform/afpd": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/app-manager": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/central-gateway": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/collectd": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/configure": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/dnsmasq": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/dokku": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/elasticsearch": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/frontend": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/haproxy": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hardware": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hostapd": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hostname-avahi": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/hostname-smb": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/http-proxy": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/ldap": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/monitoring": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/mysql": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/ptw": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/pulseaudio": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/rabbitmq": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/redis": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/skvs": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/smb": "tag-timestamp #124124",
-      "quay.io/experimentalplatform/systemd-proxy": "tag-timestamp #124124",
-      "quay.io/protonetinc/german-shepherd": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-backup": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-nginx": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-owner": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-protosync": "tag-timestamp #124124",
-      "quay.io/protonetinc/soul-smb": "tag-timestamp #124124"
+      "quay.io/experimentalplatform/afpd": "2016-09-01-1200",
+      "quay.io/experimentalplatform/app-manager": "2016-09-01-1200",
+      "quay.io/experimentalplatform/central-gateway": "2016-09-01-1200",
+      "quay.io/experimentalplatform/collectd": "2016-09-01-1200",
+      "quay.io/experimentalplatform/configure": "2016-09-01-1200",
+      "quay.io/experimentalplatform/dnsmasq": "2016-09-01-1200",
+      "quay.io/experimentalplatform/dokku": "2016-09-01-1200",
+      "quay.io/experimentalplatform/elasticsearch": "2016-09-01-1200",
+      "quay.io/experimentalplatform/frontend": "2016-09-01-1200",
+      "quay.io/experimentalplatform/haproxy": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hardware": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hostapd": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hostname-avahi": "2016-09-01-1200",
+      "quay.io/experimentalplatform/hostname-smb": "2016-09-01-1200",
+      "quay.io/experimentalplatform/http-proxy": "2016-09-01-1200",
+      "quay.io/experimentalplatform/ldap": "2016-09-01-1200",
+      "quay.io/experimentalplatform/monitoring": "2016-09-01-1200",
+      "quay.io/experimentalplatform/mysql": "2016-09-01-1200",
+      "quay.io/experimentalplatform/ptw": "2016-09-01-1200",
+      "quay.io/experimentalplatform/pulseaudio": "2016-09-01-1200",
+      "quay.io/experimentalplatform/rabbitmq": "2016-09-01-1200",
+      "quay.io/experimentalplatform/redis": "2016-09-01-1200",
+      "quay.io/experimentalplatform/skvs": "2016-09-01-1200",
+      "quay.io/experimentalplatform/smb": "2016-09-01-1200",
+      "quay.io/experimentalplatform/systemd-proxy": "2016-09-01-1200",
+      "quay.io/protonetinc/german-shepherd": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-backup": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-nginx": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-owner": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-protosync": "2016-09-01-1200",
+      "quay.io/protonetinc/soul-smb": "2016-09-01-1200"
     }
   }
 ]`
@@ -218,8 +225,8 @@ func TestRenamedImages3(t *testing.T) {
 			TargetChannel: "tgt",
 		},
 	}
-	tagTimestamp := "tag-timestamp #124124"
-	isoTimestamp := "wtf_timestamp %3215123"
+	tagTimestamp := "2016-09-01-1200"
+	isoTimestamp := "2016-09-01T12:00:00Z"
 	err = updateJSON(repo, opts, tagTimestamp, isoTimestamp)
 	assert.Nil(t, err)
 
@@ -228,7 +235,7 @@ func TestRenamedImages3(t *testing.T) {
     "build": 1,
     "codename": "Development Alpha",
     "url": "foobar",
-    "published_at": "wtf_timestamp %3215123",
+    "published_at": "2016-09-01T12:00:00Z",
     "images": {
       "quay.io/experimentalplatform/afpd": "2016-08-24-1402",
       "quay.io/experimentalplatform/app-manager": "2016-08-24-1402",
@@ -301,8 +308,8 @@ func TestRenamedImagesBuildIncrement(t *testing.T) {
 			Action:        "create",
 		},
 	}
-	tagTimestamp := "tag-timestamp #124124"
-	isoTimestamp := "wtf_timestamp %3215123"
+	tagTimestamp := "2016-09-01-1200"
+	isoTimestamp := "2016-09-01T12:00:00Z"
 	err = updateJSON(repo, opts, tagTimestamp, isoTimestamp)
 	assert.Nil(t, err)
 
@@ -311,7 +318,7 @@ func TestRenamedImagesBuildIncrement(t *testing.T) {
     "build": 213456,
     "codename": "Zeitgeist",
     "url": "https://www.example.com/",
-    "published_at": "wtf_timestamp %3215123",
+    "published_at": "2016-09-01T12:00:00Z",
     "images": {}
   }
 ]`
@@ -320,3 +327,809 @@ func TestRenamedImagesBuildIncrement(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedJSON, actualJSON)
 }
+
+// TestReleaseResult tests whether a dry run records the planned retagging in the result document
+func TestReleaseResult(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "result-source.json"), []byte(testOldJSON), 0644)
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "result-target.json"), []byte(testOldJSON), 0644)
+
+	opts := taggerOptions{
+		Output: "json",
+		Args: taggerOptionsArgs{
+			SourceChannel: "result-source",
+			TargetChannel: "result-target",
+			Action:        "create",
+		},
+	}
+	result := newReleaseResult(opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	err = updateChannels(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z", result)
+	assert.Nil(t, err)
+
+	assert.True(t, result.DryRun)
+	assert.Equal(t, int32(213455), result.SourceBuild)
+	assert.Len(t, result.Channels, 1)
+	assert.Equal(t, "result-target", result.Channels[0].Channel)
+	assert.Equal(t, int32(213455), result.Channels[0].PreviousBuild)
+	assert.Equal(t, int32(213456), result.Channels[0].Build)
+	assert.Equal(t, "2016-09-01-1200", result.Channels[0].Tag)
+	assert.Len(t, result.Images, len(result.Channels[0].Data.Images))
+	for _, image := range result.Images {
+		assert.Equal(t, imagePlanned, image.Status)
+		assert.Equal(t, "2016-09-01-1200", image.Tag)
+	}
+	assert.Empty(t, result.CommitID)
+	assert.False(t, result.Pushed)
+
+	data, err := json.Marshal(result)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"pushed":false`)
+}
+
+// TestRenamedImagesMultipleTargets tests whether all target channels
+// are written with their own build numbers
+func TestRenamedImagesMultipleTargets(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	var oldJSON1 = `[
+  {
+    "build": 213455,
+    "codename": "Development Alpha",
+    "url": "foobar",
+    "published_at": "2016-08-24T14:02:38Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "2016-08-24-1402"
+    }
+  }
+]`
+
+	srcJSONPath := path.Join(repo.GetDirectory(), "source.json")
+	tgtJSONPath := path.Join(repo.GetDirectory(), "tgt.json")
+	ioutil.WriteFile(srcJSONPath, []byte(oldJSON1), 0644)
+	ioutil.WriteFile(tgtJSONPath, []byte(oldJSON1), 0644)
+
+	opts := taggerOptions{
+		Commit: false,
+		Args: taggerOptionsArgs{
+			Action:             "create",
+			SourceChannel:      "source",
+			TargetChannel:      "tgt",
+			MoreTargetChannels: []string{"tgt2"},
+		},
+	}
+	tagTimestamp := "2016-09-01-1200"
+	isoTimestamp := "2016-09-01T12:00:00Z"
+	err = updateJSON(repo, opts, tagTimestamp, isoTimestamp)
+	assert.Nil(t, err)
+
+	var expectedJSON1 = `[
+  {
+    "build": 213456,
+    "codename": "Development Alpha",
+    "url": "foobar",
+    "published_at": "2016-09-01T12:00:00Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "2016-09-01-1200"
+    }
+  }
+]`
+
+	var expectedJSON2 = `[
+  {
+    "build": 1,
+    "codename": "Development Alpha",
+    "url": "foobar",
+    "published_at": "2016-09-01T12:00:00Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "2016-09-01-1200"
+    }
+  }
+]`
+
+	actualJSON1, err := repo.DumpChannel("tgt")
+	assert.Nil(t, err)
+	assert.Equal(t, expectedJSON1, actualJSON1)
+
+	actualJSON2, err := repo.DumpChannel("tgt2")
+	assert.Nil(t, err)
+	assert.Equal(t, expectedJSON2, actualJSON2)
+
+	// the source channel must stay untouched
+	actualSourceJSON, err := repo.DumpChannel("source")
+	assert.Nil(t, err)
+	assert.Equal(t, oldJSON1, actualSourceJSON)
+}
+
+func TestRenamedImagesDuplicateTarget(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	opts := taggerOptions{
+		Args: taggerOptionsArgs{
+			Action:             "copy",
+			SourceChannel:      "source",
+			TargetChannel:      "tgt",
+			MoreTargetChannels: []string{"tgt"},
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+}
+
+func TestCheckArgs(t *testing.T) {
+	opts := taggerOptions{Output: "text", Args: taggerOptionsArgs{Action: "history", SourceChannel: "beta"}}
+	assert.Nil(t, checkArgs(&opts))
+
+	opts.Args.TargetChannel = "stable"
+	assert.NotNil(t, checkArgs(&opts), "history takes a single channel")
+
+	opts.Args.Action = "create"
+	assert.Nil(t, checkArgs(&opts))
+
+	opts.Args.TargetChannel = ""
+	assert.NotNil(t, checkArgs(&opts), "create needs a target channel")
+
+	opts.Args.Action = "tag-history"
+	assert.NotNil(t, checkArgs(&opts), "tag-history needs an image and a tag")
+
+	opts.Args.TargetChannel = "beta"
+	assert.Nil(t, checkArgs(&opts))
+
+	opts.Args.Action = "create"
+	opts.Force = true
+	assert.NotNil(t, checkArgs(&opts), "--force needs a policy")
+
+	opts.Force = false
+	opts.ApprovedBy = []string{"alice"}
+	assert.NotNil(t, checkArgs(&opts), "--approved-by needs a policy")
+
+	opts.Policy = "policy.json"
+	opts.Force = true
+	assert.Nil(t, checkArgs(&opts))
+
+	opts.Args.Action = "delete"
+	assert.NotNil(t, checkArgs(&opts))
+}
+
+func TestEmptySourceChannel(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	srcJSONPath := path.Join(repo.GetDirectory(), "source.json")
+	ioutil.WriteFile(srcJSONPath, []byte("[]"), 0644)
+
+	opts := taggerOptions{
+		Args: taggerOptionsArgs{
+			Action:        "copy",
+			SourceChannel: "source",
+			TargetChannel: "tgt",
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+
+	// the clone contains the real channels as well
+	results, err := checkChannels(repo)
+	assert.Nil(t, err)
+	assert.Contains(t, results, channelValidation{Channel: "source", Valid: false, Errors: []string{"channel contains no builds"}})
+}
+
+// TestSchemaUpToDate makes sure the published schema matches BuildsDatum,
+// regenerate it with 'tagger schema > builds.schema.json'
+func TestSchemaUpToDate(t *testing.T) {
+	published, err := ioutil.ReadFile("builds.schema.json")
+	assert.Nil(t, err)
+
+	generated, err := json.MarshalIndent(git.BuildsSchema(), "", "  ")
+	assert.Nil(t, err)
+	assert.Equal(t, string(published), string(generated)+"\n")
+}
+
+// TestSelectivePromotion tests whether only the selected images are
+// promoted while the target channel keeps its tags for the others
+func TestSelectivePromotion(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	var sourceJSON = `[
+  {
+    "build": 213455,
+    "codename": "Development Alpha",
+    "url": "foobar",
+    "published_at": "2016-08-24T14:02:38Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "2016-08-24-1402",
+      "quay.io/experimentalplatform/smb": "2016-08-24-1402",
+      "quay.io/protonetinc/soul-smb": "2016-08-24-1402"
+    }
+  }
+]`
+
+	var targetJSON = `[
+  {
+    "build": 17,
+    "codename": "Development Alpha",
+    "url": "foobar",
+    "published_at": "2016-08-01T10:00:00Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "2016-08-01-1000",
+      "quay.io/experimentalplatform/smb": "2016-08-01-1000",
+      "quay.io/experimentalplatform/old": "2016-08-01-1000",
+      "quay.io/protonetinc/soul-smb": "2016-08-01-1000"
+    },
+    "image_ids": {
+      "quay.io/experimentalplatform/skvs": "9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623",
+      "quay.io/experimentalplatform/smb": "9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623"
+    }
+  }
+]`
+
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "source.json"), []byte(sourceJSON), 0644)
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "tgt.json"), []byte(targetJSON), 0644)
+
+	opts := taggerOptions{
+		Only:    []string{"quay.io/experimentalplatform/*"},
+		Exclude: []string{"*/*/smb"},
+		Args: taggerOptionsArgs{
+			Action:        "create",
+			SourceChannel: "source",
+			TargetChannel: "tgt",
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+
+	var expectedJSON = `[
+  {
+    "build": 18,
+    "codename": "Development Alpha",
+    "url": "foobar",
+    "published_at": "2016-09-01T12:00:00Z",
+    "images": {
+      "quay.io/experimentalplatform/old": "2016-08-01-1000",
+      "quay.io/experimentalplatform/skvs": "2016-09-01-1200",
+      "quay.io/experimentalplatform/smb": "2016-08-01-1000",
+      "quay.io/protonetinc/soul-smb": "2016-08-01-1000"
+    },
+    "image_ids": {
+      "quay.io/experimentalplatform/smb": "9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623"
+    }
+  }
+]`
+
+	actualJSON, err := repo.DumpChannel("tgt")
+	assert.Nil(t, err)
+	assert.Equal(t, expectedJSON, actualJSON)
+}
+
+func TestSelectImages(t *testing.T) {
+	images := map[string]string{
+		"quay.io/experimentalplatform/skvs": "a",
+		"quay.io/protonetinc/soul-smb":      "b",
+	}
+
+	selected, err := selectImages(images, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, images, selected)
+
+	selected, err = selectImages(images, []string{"quay.io/protonetinc/*"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"quay.io/protonetinc/soul-smb": "b"}, selected)
+
+	_, err = selectImages(images, nil, []string{"*/*/*"})
+	assert.NotNil(t, err, "Excluding everything is an error")
+
+	_, err = selectImages(images, []string{"[quay"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestReleaseTag(t *testing.T) {
+	tag, err := releaseTag(nil, "", "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, "2016-09-01-1200", tag)
+
+	tag, err = releaseTag(nil, "{{.Channel}}-{{.Build}}-{{.Timestamp}}", "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, "beta-12-2016-09-01-1200", tag)
+
+	tag, err = releaseTag(nil, `1.{{.Build}}.0-{{.Time.Format "20060102150405"}}`, "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.12.0-20160901120000", tag)
+
+	_, err = releaseTag(nil, "{{.Channel}}:{{.Build}}", "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err, "':' is not allowed in tags")
+
+	_, err = releaseTag(nil, "{{.Unknown}}", "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+
+	// the commit is only looked up when the template really uses it
+	tag, err = releaseTag(nil, "ShortSHA.{{.Build}}", "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, "ShortSHA.12", tag)
+
+	_, err = releaseTag(nil, "{{with $d := .}}{{$d.ShortSHA}}{{end}}", "beta", 12, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "There is no builds repo")
+}
+
+// TestTagTemplatePerChannel tests whether every target channel gets its own tag
+func TestTagTemplatePerChannel(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	srcJSONPath := path.Join(repo.GetDirectory(), "source.json")
+	ioutil.WriteFile(srcJSONPath, []byte(testOldJSON), 0644)
+
+	opts := taggerOptions{
+		TagTemplate: "{{.Channel}}-{{.Build}}",
+		Args: taggerOptionsArgs{
+			Action:             "create",
+			SourceChannel:      "source",
+			TargetChannel:      "tgt",
+			MoreTargetChannels: []string{"tgt2"},
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+
+	tgt, err := repo.LoadChannel("tgt")
+	assert.Nil(t, err)
+	assert.Equal(t, "tgt-1", tgt[0].Images["quay.io/experimentalplatform/skvs"])
+
+	tgt2, err := repo.LoadChannel("tgt2")
+	assert.Nil(t, err)
+	assert.Equal(t, "tgt2-1", tgt2[0].Images["quay.io/experimentalplatform/skvs"])
+}
+
+func TestReleaseTimestamps(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time {
+		return time.Date(2016, 9, 1, 14, 5, 7, 0, time.FixedZone("CEST", 2*60*60))
+	}
+
+	tagTimestamp, isoTimestamp, err := releaseTimestamps(taggerOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "2016-09-01-1205", tagTimestamp)
+	assert.Equal(t, "2016-09-01T12:05:07Z", isoTimestamp)
+
+	tagTimestamp, isoTimestamp, err = releaseTimestamps(taggerOptions{Timestamp: "2016-08-10T12:00:00+02:00"})
+	assert.Nil(t, err)
+	assert.Equal(t, "2016-08-10-1000", tagTimestamp)
+	assert.Equal(t, "2016-08-10T10:00:00Z", isoTimestamp)
+
+	_, _, err = releaseTimestamps(taggerOptions{Timestamp: "yesterday"})
+	assert.NotNil(t, err)
+}
+
+// captureStdout returns what f prints to stdout
+func captureStdout(t *testing.T, f func()) []byte {
+	reader, writer, err := os.Pipe()
+	assert.Nil(t, err)
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- data
+	}()
+
+	f()
+	writer.Close()
+	return <-output
+}
+
+func TestExecuteReleaseResult(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		opts       taggerOptions
+		optionsErr error
+		code       int
+	}{
+		{"invalid options", taggerOptions{}, withExitCode(exitUsage, errors.New("--force requires --policy")), exitUsage},
+		{"channel key", taggerOptions{ChannelPubKey: "/nonexistent/channel.pub"}, nil, exitUsage},
+		{"clone", taggerOptions{GitClient: "unknown"}, nil, exitClone},
+	} {
+		test.opts.Output = "json"
+		test.opts.Args = taggerOptionsArgs{Action: "create", SourceChannel: "soul3", TargetChannel: "soul3-test"}
+
+		var err error
+		output := captureStdout(t, func() {
+			err = execute(test.opts, test.optionsErr)
+		})
+		assert.NotNil(t, err, test.name)
+		assert.Equal(t, test.code, exitCode(err), test.name)
+
+		// stdout holds exactly one document, telling what went wrong
+		var result releaseResult
+		decoder := json.NewDecoder(bytes.NewReader(output))
+		assert.Nil(t, decoder.Decode(&result), test.name)
+		assert.Equal(t, io.EOF, decoder.Decode(&json.RawMessage{}), test.name)
+
+		assert.Equal(t, "create", result.Action, test.name)
+		assert.Equal(t, "soul3", result.SourceChannel, test.name)
+		assert.Equal(t, err.Error(), result.Error, test.name)
+		assert.Empty(t, result.Channels, test.name)
+	}
+
+	// text output prints no document
+	output := captureStdout(t, func() {
+		execute(taggerOptions{Output: "text", Args: taggerOptionsArgs{Action: "create"}}, withExitCode(exitUsage, errors.New("Usage")))
+	})
+	assert.Empty(t, output)
+}
+
+func TestExecuteErrorDocument(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "")()
+	defer setTestEnv("TOKEN_PROTONET", "")()
+
+	for _, test := range []struct {
+		opts taggerOptions
+		code int
+	}{
+		{taggerOptions{GitClient: "unknown", Args: taggerOptionsArgs{Action: "history", SourceChannel: "soul3"}}, exitClone},
+		{taggerOptions{GitClient: "unknown", Args: taggerOptionsArgs{Action: "validate"}}, exitClone},
+		{taggerOptions{GitClient: "libgit", Args: taggerOptionsArgs{Action: "verify", SourceChannel: "soul3"}}, exitCredentials},
+		{taggerOptions{GitClient: "libgit", Args: taggerOptionsArgs{Action: "prune"}}, exitCredentials},
+		{taggerOptions{GitClient: "libgit", At: "yesterday", Args: taggerOptionsArgs{Action: "snapshot", SourceChannel: "soul3"}}, exitUsage},
+		{taggerOptions{At: "yesterday", Args: taggerOptionsArgs{Action: "tag-history", SourceChannel: "quay.io/experimentalplatform/skvs", TargetChannel: "development"}}, exitUsage},
+	} {
+		test.opts.Output = "json"
+		action := test.opts.Args.Action
+
+		var err error
+		output := captureStdout(t, func() {
+			err = execute(test.opts, nil)
+		})
+		assert.Equal(t, test.code, exitCode(err), action)
+
+		var document errorDocument
+		decoder := json.NewDecoder(bytes.NewReader(output))
+		assert.Nil(t, decoder.Decode(&document), action)
+		assert.Equal(t, io.EOF, decoder.Decode(&json.RawMessage{}), action)
+		assert.Equal(t, errorDocument{Action: action, Error: err.Error()}, document)
+	}
+}
+
+func TestCopyRejectsCreateOptions(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	for _, opts := range []taggerOptions{
+		{TargetRegistry: "mirror.example.com"},
+		{TagTemplate: "{{.Build}}"},
+		{SkipUnchanged: true},
+		{SignatureKey: "ci.pub"},
+		{SigningKey: "release.key"},
+	} {
+		opts.Args = taggerOptionsArgs{Action: "copy", SourceChannel: "beta", TargetChannel: "stable"}
+		err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "'create' action")
+		assert.Equal(t, exitUsage, exitCode(err))
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	assert.Equal(t, exitFailure, exitCode(errors.New("failure")))
+	assert.Nil(t, withExitCode(exitRegistry, nil))
+
+	// the first category assigned to an error is kept
+	err := withExitCode(exitRegistry, withExitCode(exitPolicy, errors.New("refused")))
+	assert.Equal(t, exitPolicy, exitCode(err))
+	assert.Equal(t, "refused", err.Error())
+
+	// moved tags take precedence over any other category
+	err = partialRetag(withExitCode(exitRegistry, errors.New("unreachable")))
+	assert.Equal(t, exitPartialRetag, exitCode(err))
+	assert.Equal(t, "unreachable", err.Error())
+	assert.Nil(t, partialRetag(nil))
+
+	defer setTestEnv("TOKEN_PLATFORM", "")()
+	assert.Equal(t, exitCredentials, exitCode(checkIfTokensPresent()))
+
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	opts := taggerOptions{Args: taggerOptionsArgs{Action: "history", SourceChannel: "development", TargetChannel: "beta"}}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Equal(t, exitUsage, exitCode(err))
+
+	// invalid options only found while releasing are usage errors as well
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "exit-source.json"), []byte(testOldJSON), 0644)
+	for _, test := range []struct {
+		name string
+		opts taggerOptions
+	}{
+		{"tag template", taggerOptions{TagTemplate: "{{.Channel"}},
+		{"invalid tag", taggerOptions{TagTemplate: "{{.Channel}} {{.Build}}"}},
+		{"pattern", taggerOptions{Only: []string{"["}}},
+		{"no match", taggerOptions{Exclude: []string{"quay.io/*/*"}}},
+		{"channel twice", taggerOptions{Args: taggerOptionsArgs{MoreTargetChannels: []string{"exit-target"}}}},
+		{"release notes", taggerOptions{ReleaseNotes: "../notes"}},
+		{"signature key", taggerOptions{SignatureKey: "/nonexistent/ci.pub"}},
+		{"signing key", taggerOptions{SigningKey: "/nonexistent/release.key"}},
+	} {
+		test.opts.Args.Action = "create"
+		test.opts.Args.SourceChannel = "exit-source"
+		test.opts.Args.TargetChannel = "exit-target"
+		err = updateJSON(repo, test.opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+		assert.NotNil(t, err, test.name)
+		assert.Equal(t, exitUsage, exitCode(err), test.name)
+	}
+
+	opts = taggerOptions{Timestamp: "yesterday", Args: taggerOptionsArgs{Action: "create", SourceChannel: "exit-source", TargetChannel: "exit-target"}}
+	err = release(repo, opts, nil)
+	assert.Equal(t, exitUsage, exitCode(err))
+}
+
+func TestGenerateChannelKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagger-keys")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, "release")
+	err = generateChannelKeys(name, "text")
+	assert.Nil(t, err)
+
+	info, err := os.Stat(name + ".key")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	opts := taggerOptions{ChannelKey: name + ".key", ChannelPubKey: name + ".pub"}
+	repoOptions, err := channelKeyOptions(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, repoOptions.ChannelSigningKey)
+	assert.NotNil(t, repoOptions.ChannelPublicKey)
+
+	// existing keys are kept
+	err = generateChannelKeys(name, "text")
+	assert.NotNil(t, err)
+	loaded, _ := git.LoadChannelSigningKey(name + ".key")
+	assert.Equal(t, repoOptions.ChannelSigningKey, loaded)
+}
+
+func TestBuildReleaseNotes(t *testing.T) {
+	previous := git.BuildsDatum{
+		Build: 41,
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs":      "2016-08-01-1200",
+			"quay.io/experimentalplatform/smb":       "2016-08-01-1200",
+			"quay.io/experimentalplatform/configure": "2016-08-01-1200",
+			"quay.io/experimentalplatform/dokku":     "2016-08-01-1200",
+			"quay.io/experimentalplatform/ldap":      "2016-08-01-1200",
+		},
+		ImageIDs: map[string]string{
+			"quay.io/experimentalplatform/skvs": "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc",
+			"quay.io/experimentalplatform/smb":  "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb",
+		},
+	}
+	next := git.BuildsDatum{
+		Build:       42,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs":      "2016-09-01-1200",
+			"quay.io/experimentalplatform/smb":       "2016-09-01-1200",
+			"quay.io/experimentalplatform/configure": "2016-09-01-1200",
+			"quay.io/experimentalplatform/dokku":     "2016-08-01-1200",
+			"quay.io/experimentalplatform/frontend":  "2016-09-01-1200",
+		},
+		ImageIDs: map[string]string{
+			"quay.io/experimentalplatform/skvs": "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb",
+			"quay.io/experimentalplatform/smb":  "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb",
+		},
+	}
+
+	notes := buildReleaseNotes("stable", "beta", &previous, next)
+	assert.Equal(t, int32(41), notes.PreviousBuild)
+	assert.Equal(t, []imageChange{
+		{Image: "quay.io/experimentalplatform/configure", Change: imageUpdated, OldTag: "2016-08-01-1200", NewTag: "2016-09-01-1200"},
+		{Image: "quay.io/experimentalplatform/frontend", Change: imageAdded, NewTag: "2016-09-01-1200"},
+		{Image: "quay.io/experimentalplatform/ldap", Change: imageRemoved, OldTag: "2016-08-01-1200"},
+		{Image: "quay.io/experimentalplatform/skvs", Change: imageUpdated, OldTag: "2016-08-01-1200", NewTag: "2016-09-01-1200",
+			OldID: "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc", NewID: "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb"},
+	}, notes.Changes)
+
+	markdown := notes.markdown()
+	assert.Contains(t, markdown, "# stable build 42\n\nReleased 2016-09-01T12:00:00Z from channel beta, previous build 41.")
+	assert.Contains(t, markdown, "| quay.io/experimentalplatform/skvs | updated | 2016-08-01-1200 | 2016-09-01-1200 | 1319cc8604ca | fb38f27d3c97 |")
+
+	notes = buildReleaseNotes("stable", "beta", &next, next)
+	assert.Empty(t, notes.Changes)
+	assert.Contains(t, notes.markdown(), "No images changed.")
+
+	// builds released before manifest digests were recorded compare by docker image id
+	withDigests := copyDatum(next)
+	withDigests.ManifestDigests = map[string]string{
+		"quay.io/experimentalplatform/skvs": "sha256:9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623",
+	}
+	notes = buildReleaseNotes("stable", "beta", &next, withDigests)
+	assert.Empty(t, notes.Changes)
+}
+
+func TestReleaseNotesFiles(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	srcJSONPath := path.Join(repo.GetDirectory(), "source.json")
+	ioutil.WriteFile(srcJSONPath, []byte(testOldJSON), 0644)
+
+	opts := taggerOptions{
+		ReleaseNotes:    "release-notes",
+		ReleaseNotesURL: "https://github.com/protonet/builds/blob/master/",
+		Args: taggerOptionsArgs{
+			Action:        "copy",
+			SourceChannel: "source",
+			TargetChannel: "tgt",
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+
+	builds, err := repo.LoadChannel("tgt")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://github.com/protonet/builds/blob/master/release-notes/tgt-1.md", builds[0].URL)
+
+	data, err := ioutil.ReadFile(path.Join(repo.GetDirectory(), "release-notes", "tgt-1.json"))
+	assert.Nil(t, err)
+
+	var notes releaseNotes
+	err = json.Unmarshal(data, &notes)
+	assert.Nil(t, err)
+	assert.Equal(t, "source", notes.SourceChannel)
+	assert.Len(t, notes.Changes, 31)
+
+	_, err = os.Stat(path.Join(repo.GetDirectory(), "release-notes", "tgt-1.md"))
+	assert.Nil(t, err)
+
+	// an explicit url is kept, notes outside the repo are refused
+	opts.URL = "https://example.com/notes"
+	opts.Args.TargetChannel = "tgt2"
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+	builds, _ = repo.LoadChannel("tgt2")
+	assert.Equal(t, "https://example.com/notes", builds[0].URL)
+
+	opts.ReleaseNotes = "../notes"
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+
+	// notes in the root of the repo would be taken for channels
+	for _, dir := range []string{".", "./", "notes/.."} {
+		opts.ReleaseNotes = dir
+		opts.Args.TargetChannel = "tgt3"
+		err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+		assert.NotNil(t, err, dir)
+		assert.Contains(t, err.Error(), "must not be the root", dir)
+	}
+
+	channels, err := repo.ListChannels()
+	assert.Nil(t, err)
+	assert.NotContains(t, channels, "tgt3-1")
+}
+
+func TestSkipUnchanged(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	var sourceJSON = `[
+  {
+    "build": 3,
+    "codename": "",
+    "url": "",
+    "published_at": "2016-08-24T14:02:38Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "development"
+    }
+  }
+]`
+
+	var targetJSON = `[
+  {
+    "build": 2,
+    "codename": "",
+    "url": "",
+    "published_at": "2016-08-20T14:02:38Z",
+    "images": {
+      "quay.io/experimentalplatform/skvs": "%s"
+    }
+  }
+]`
+
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "development.json"), []byte(sourceJSON), 0644)
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "same.json"), []byte(fmt.Sprintf(targetJSON, "development")), 0644)
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), "older.json"), []byte(fmt.Sprintf(targetJSON, "2016-08-11-1153")), 0644)
+
+	opts := taggerOptions{
+		SkipUnchanged: true,
+		Args: taggerOptionsArgs{
+			Action:             "create",
+			SourceChannel:      "development",
+			TargetChannel:      "same",
+			MoreTargetChannels: []string{"older"},
+		},
+	}
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("TOKEN_PROTONET", "foobar token")()
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+
+	same, err := repo.LoadChannel("same")
+	assert.Nil(t, err)
+	assert.Equal(t, "development", same[0].Images["quay.io/experimentalplatform/skvs"])
+	assert.Equal(t, "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", same[0].ImageIDs["quay.io/experimentalplatform/skvs"])
+
+	older, err := repo.LoadChannel("older")
+	assert.Nil(t, err)
+	assert.Equal(t, "2016-09-01-1200", older[0].Images["quay.io/experimentalplatform/skvs"])
+}
+
+func TestUpdateJSONPartialRetag(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("TOKEN_PROTONET", "foobar token")()
+	defer setTestEnv("MIRROR_USERNAME", "mirror")()
+	defer setTestEnv("MIRROR_PASSWORD", "secret")()
+
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("development", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/multiarch": "development",
+		},
+	}})
+	assert.Nil(t, err)
+
+	// the tag of the second channel already exists once the first one is moved
+	opts := taggerOptions{
+		Commit:      true,
+		TagTemplate: `{{if eq .Channel "soul3-first"}}partial-{{.Build}}{{else}}development{{end}}`,
+		Args: taggerOptionsArgs{
+			Action:             "create",
+			SourceChannel:      "development",
+			TargetChannel:      "soul3-first",
+			MoreTargetChannels: []string{"soul3-second"},
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Tag 'development' already exists")
+	assert.Equal(t, exitPartialRetag, exitCode(err))
+
+	// the image is retagged, but cannot be copied to the other registry
+	opts = taggerOptions{
+		Commit:         true,
+		TargetRegistry: "mirror.example.com/nowhere",
+		Args: taggerOptionsArgs{
+			Action:        "create",
+			SourceChannel: "development",
+			TargetChannel: "soul3-mirrored",
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1201", "2016-09-01T12:01:00Z")
+	assert.NotNil(t, err)
+	assert.Equal(t, exitPartialRetag, exitCode(err))
+
+	for _, channel := range []string{"soul3-first", "soul3-second", "soul3-mirrored"} {
+		_, err = repo.LoadChannel(channel)
+		assert.True(t, os.IsNotExist(err), channel)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+func checkIfMirrorCredentialsPresent() error {
+	if len(os.Getenv("MIRROR_USERNAME")) == 0 {
+		return withExitCode(exitCredentials, errors.New("MIRROR_USERNAME is not set"))
+	}
+
+	if len(os.Getenv("MIRROR_PASSWORD")) == 0 {
+		return withExitCode(exitCredentials, errors.New("MIRROR_PASSWORD is not set"))
+	}
+
+	return nil
+}
+
+func newMirrorClient(registry string) *registryClient {
+	return &registryClient{
+		registry: registry,
+		username: os.Getenv("MIRROR_USERNAME"),
+		password: os.Getenv("MIRROR_PASSWORD"),
+	}
+}
+
+// mirrorImageName returns the name of an image in the target registry. The target registry
+// may be given with an organisation, which then replaces the image's organisation.
+func mirrorImageName(imageFullName, targetRegistry string) string {
+	if strings.Contains(targetRegistry, "/") {
+		return targetRegistry + "/" + path.Base(imageFullName)
+	}
+
+	return targetRegistry + "/" + strings.SplitN(imageFullName, "/", 2)[1]
+}
+
+// mirrorImageNames renames the selected images of the build to their names in the target registry,
+// all other images already have their target channel names
+func mirrorImageNames(datum *git.BuildsDatum, selected map[string]string, targetRegistry string) {
+	rename := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+
+		renamed := make(map[string]string)
+		for image, value := range m {
+			if _, ok := selected[image]; ok {
+				image = mirrorImageName(image, targetRegistry)
+			}
+			renamed[image] = value
+		}
+
+		return renamed
+	}
+
+	datum.Images = rename(datum.Images)
+	datum.ImageIDs = rename(datum.ImageIDs)
+	datum.ManifestDigests = rename(datum.ManifestDigests)
+}
+
+// copyBlob copies a blob unless the target already has it, by mounting it when both
+// repositories are in the same registry and by streaming it otherwise
+func copyBlob(source, target *registryClient, sourceRepo, targetRepo, digest string) error {
+	exists, err := target.blobExists(targetRepo, digest)
+	if err != nil {
+		return fmt.Errorf("Failed to check for blob '%s' in '%s': %s", digest, targetRepo, err.Error())
+	}
+	if exists {
+		return nil
+	}
+
+	mountFrom := ""
+	if source.registry == target.registry {
+		mountFrom = sourceRepo
+	}
+
+	location, err := target.startUpload(targetRepo, digest, mountFrom)
+	if err != nil {
+		return fmt.Errorf("Failed to upload blob '%s' to '%s': %s", digest, targetRepo, err.Error())
+	}
+	if location == "" {
+		// mounted
+		return nil
+	}
+
+	body, length, err := source.getBlob(sourceRepo, digest)
+	if err != nil {
+		return fmt.Errorf("Failed to download blob '%s' from '%s': %s", digest, sourceRepo, err.Error())
+	}
+	defer body.Close()
+
+	err = target.finishUpload(location, digest, body, length)
+	if err != nil {
+		return fmt.Errorf("Failed to upload blob '%s' to '%s': %s", digest, targetRepo, err.Error())
+	}
+
+	return nil
+}
+
+// copyManifest copies a manifest along with its blobs, or the manifests of all platforms of a
+// manifest list or OCI index, to the target repository and returns its digest
+func copyManifest(source, target *registryClient, sourceRepo, targetRepo, reference, targetReference string) (string, error) {
+	manifest, err := source.getManifest(sourceRepo, reference)
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch manifest '%s' of '%s': %s", reference, sourceRepo, err.Error())
+	}
+
+	var content struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string   `json:"digest"`
+			URLs   []string `json:"urls"`
+		} `json:"layers"`
+	}
+
+	err = json.Unmarshal(manifest.Body, &content)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse manifest '%s' of '%s': %s", reference, sourceRepo, err.Error())
+	}
+
+	switch manifest.MediaType {
+	case mediaTypeManifestList, mediaTypeOCIIndex:
+		for _, platform := range content.Manifests {
+			_, err = copyManifest(source, target, sourceRepo, targetRepo, platform.Digest, platform.Digest)
+			if err != nil {
+				return "", err
+			}
+		}
+	case mediaTypeManifest, mediaTypeOCIManifest:
+		blobs := []string{content.Config.Digest}
+		for _, layer := range content.Layers {
+			// foreign layers stay where they are
+			if len(layer.URLs) == 0 {
+				blobs = append(blobs, layer.Digest)
+			}
+		}
+
+		for _, blob := range blobs {
+			err = copyBlob(source, target, sourceRepo, targetRepo, blob)
+			if err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("Unsupported media type '%s' of manifest '%s' of '%s'", manifest.MediaType, reference, sourceRepo)
+	}
+
+	err = target.putManifest(targetRepo, targetReference, manifest)
+	if err != nil {
+		return "", fmt.Errorf("Failed to upload manifest '%s' to '%s': %s", manifest.Digest, targetRepo, err.Error())
+	}
+
+	return manifest.Digest, nil
+}
+
+// mirrorImage copies the image with the given manifest digest, or with the given tag if it
+// has no manifest digest, to the target registry under that tag and returns its manifest digest
+func mirrorImage(imageFullName, digest, tag, targetRegistry string) (string, error) {
+	org, image, token, err := parseImageName(imageFullName)
+	if err != nil {
+		return "", err
+	}
+
+	targetParts := strings.SplitN(mirrorImageName(imageFullName, targetRegistry), "/", 2)
+
+	source := newRegistryClient(strings.Split(imageFullName, "/")[0], token)
+	target := newMirrorClient(targetParts[0])
+
+	reference := tag
+	if digest != "" {
+		reference = digest
+	}
+
+	return copyManifest(source, target, org+"/"+image, targetParts[1], reference, tag)
+}
+
+// mirrorAll copies the retagged images to the target registry and returns their ids there,
+// which are only manifest digests
+func mirrorAll(images map[string]string, ids map[string]imageRef, tag, targetRegistry string) (map[string]imageRef, error) {
+	type response struct {
+		Image  string
+		Digest string
+		Error  error
+	}
+
+	count := len(images)
+	channel := make(chan response)
+
+	for k := range images {
+		imageFullName := k
+		go func() {
+			digest, err := mirrorImage(imageFullName, ids[imageFullName].ManifestDigest, tag, targetRegistry)
+			channel <- response{Image: imageFullName, Digest: digest, Error: err}
+		}()
+	}
+
+	digests := make(map[string]imageRef)
+	var err error
+	for i := 0; i < count; i++ {
+		resp := <-channel
+		if resp.Error == nil {
+			log.Printf("Image '%s': copied to '%s'", resp.Image, mirrorImageName(resp.Image, targetRegistry))
+			digests[resp.Image] = imageRef{ManifestDigest: resp.Digest}
+		} else {
+			log.Printf("Image '%s': ERROR: %s", resp.Image, resp.Error.Error())
+			err = resp.Error
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/experimental-platform/release-tagger/git"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func TestMirrorImageName(t *testing.T) {
+	assert.Equal(t, "registry.example.com/experimentalplatform/skvs", mirrorImageName("quay.io/experimentalplatform/skvs", "registry.example.com"))
+	assert.Equal(t, "registry.example.com/customer/skvs", mirrorImageName("quay.io/experimentalplatform/skvs", "registry.example.com/customer"))
+
+	datum := git.BuildsDatum{
+		Images:          map[string]string{"quay.io/experimentalplatform/skvs": "2016-09-01-1200", "mirror.example.com/customer/smb": "2016-08-01-1200"},
+		ManifestDigests: map[string]string{"quay.io/experimentalplatform/skvs": "sha256:fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb"},
+	}
+	mirrorImageNames(&datum, map[string]string{"quay.io/experimentalplatform/skvs": "development"}, "mirror.example.com/customer")
+	assert.Equal(t, map[string]string{"mirror.example.com/customer/skvs": "2016-09-01-1200", "mirror.example.com/customer/smb": "2016-08-01-1200"}, datum.Images)
+	assert.Equal(t, map[string]string{"mirror.example.com/customer/skvs": "sha256:fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb"}, datum.ManifestDigests)
+	assert.Nil(t, datum.ImageIDs)
+}
+
+func TestMirrorAll(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("MIRROR_USERNAME", "mirror")()
+	defer setTestEnv("MIRROR_PASSWORD", "secret")()
+	listDigest := manifestDigest([]byte(multiArchList))
+
+	images := map[string]string{"quay.io/experimentalplatform/multiarch": "development"}
+	ids := map[string]imageRef{"quay.io/experimentalplatform/multiarch": {DockerImageID: "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", ManifestDigest: listDigest}}
+	mirrored := map[string]imageRef{"quay.io/experimentalplatform/multiarch": {ManifestDigest: listDigest}}
+
+	// another registry gets all blobs streamed
+	digests, err := mirrorAll(images, ids, "2016-09-01-1200", "mirror.example.com/customer")
+	assert.Nil(t, err)
+	assert.Equal(t, mirrored, digests)
+	assert.Equal(t, listDigest, mirrorRegistry.tags["2016-09-01-1200"])
+	assert.Len(t, mirrorRegistry.manifests, 3)
+	assert.Equal(t, 4, mirrorRegistry.streamed)
+	assert.Equal(t, 0, mirrorRegistry.mounted)
+
+	// blobs already present are not copied again
+	_, err = mirrorAll(images, ids, "2016-09-02-1200", "mirror.example.com/customer")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, mirrorRegistry.streamed)
+
+	// within the same registry the blobs are mounted
+	digests, err = mirrorAll(images, ids, "2016-09-01-1200", "quay.io/mounted")
+	assert.Nil(t, err)
+	assert.Equal(t, mirrored, digests)
+	assert.Equal(t, 4, mountRegistry.mounted)
+	assert.Equal(t, 0, mountRegistry.streamed)
+
+	restore := setTestEnv("MIRROR_PASSWORD", "wrong")
+	defer restore()
+	_, err = mirrorAll(images, ids, "2016-09-03-1200", "mirror.example.com/customer")
+	assert.NotNil(t, err)
+}
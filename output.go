@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// documentPrinted tells whether the action printed its JSON document, stdout holds no other
+var documentPrinted bool
+
+// printJSON prints the JSON document of an action with --output json
+func printJSON(document interface{}) error {
+	documentPrinted = true
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+// errorDocument is printed with --output json by actions failing before they print their own document
+type errorDocument struct {
+	Action string `json:"action"`
+	Error  string `json:"error"`
+}
+
+// printErrorJSON prints the error document, unless the action already printed its document
+func printErrorJSON(action string, err error) error {
+	if documentPrinted {
+		return nil
+	}
+
+	return printJSON(errorDocument{Action: action, Error: err.Error()})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+// promotionRule allows releasing from one channel to another
+type promotionRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// MinSoak is the minimum time the source build has to be published in the source channel, e.g. "72h"
+	MinSoak string `json:"min_soak"`
+	// Approvals is the number of people who have to approve the promotion
+	Approvals int `json:"approvals"`
+
+	minSoak time.Duration
+}
+
+// channelRule applies to every release to a channel, regardless of the source channel
+type channelRule struct {
+	MinSoak string `json:"min_soak"`
+
+	minSoak time.Duration
+}
+
+// promotionPolicy lists the allowed promotions between channels, e.g.
+//
+//	{
+//	  "promotions": [{"from": "beta", "to": "stable", "min_soak": "72h", "approvals": 2}],
+//	  "channels": {"stable": {"min_soak": "48h"}}
+//	}
+type promotionPolicy struct {
+	Promotions []promotionRule         `json:"promotions"`
+	Channels   map[string]*channelRule `json:"channels"`
+}
+
+// soakError is returned when the source build has not been published long enough
+type soakError struct {
+	s string
+}
+
+func (e *soakError) Error() string {
+	return e.s
+}
+
+func loadPolicy(fileName string) (*promotionPolicy, error) {
+	rawData, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy promotionPolicy
+	err = json.Unmarshal(rawData, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse policy file '%s': %s", fileName, err.Error())
+	}
+
+	for i, rule := range policy.Promotions {
+		if rule.From == "" || rule.To == "" {
+			return nil, fmt.Errorf("Policy rule #%d needs both 'from' and 'to'", i)
+		}
+
+		if rule.MinSoak != "" {
+			policy.Promotions[i].minSoak, err = time.ParseDuration(rule.MinSoak)
+			if err != nil {
+				return nil, fmt.Errorf("Policy rule #%d: %s", i, err.Error())
+			}
+		}
+	}
+
+	for channel, rule := range policy.Channels {
+		if rule != nil && rule.MinSoak != "" {
+			rule.minSoak, err = time.ParseDuration(rule.MinSoak)
+			if err != nil {
+				return nil, fmt.Errorf("Policy for channel '%s': %s", channel, err.Error())
+			}
+		}
+	}
+
+	return &policy, nil
+}
+
+func (p *promotionPolicy) findRule(sourceChannel, targetChannel string) *promotionRule {
+	for i, rule := range p.Promotions {
+		if rule.From == sourceChannel && rule.To == targetChannel {
+			return &p.Promotions[i]
+		}
+	}
+
+	return nil
+}
+
+// check returns an error if the policy does not allow releasing from the source to the target channel
+func (p *promotionPolicy) check(sourceChannel, targetChannel string, approvers []string) error {
+	rule := p.findRule(sourceChannel, targetChannel)
+	if rule == nil {
+		return fmt.Errorf("Promotion from '%s' to '%s' is not allowed by the policy", sourceChannel, targetChannel)
+	}
+
+	approved := make(map[string]bool)
+	for _, approver := range approvers {
+		approved[approver] = true
+	}
+
+	if len(approved) < rule.Approvals {
+		return fmt.Errorf("Promotion from '%s' to '%s' requires %d approvals, got %d", sourceChannel, targetChannel, rule.Approvals, len(approved))
+	}
+
+	return nil
+}
+
+// requiredSoak returns the minimum time a build has to be published in the source channel
+// before it may be released to the target channel
+func (p *promotionPolicy) requiredSoak(sourceChannel, targetChannel string) time.Duration {
+	var minSoak time.Duration
+
+	if rule := p.findRule(sourceChannel, targetChannel); rule != nil {
+		minSoak = rule.minSoak
+	}
+
+	if rule, ok := p.Channels[targetChannel]; ok && rule != nil && rule.minSoak > minSoak {
+		minSoak = rule.minSoak
+	}
+
+	return minSoak
+}
+
+// checkSoak returns a *soakError if the source build was published less than minSoak before releaseTime
+func checkSoak(sourceChannel string, sourceBuild git.BuildsDatum, targetChannel string, releaseTime time.Time, minSoak time.Duration) error {
+	publishedAt, err := time.Parse(time.RFC3339, sourceBuild.PublishedAt)
+	if err != nil {
+		return err
+	}
+
+	soak := releaseTime.Sub(publishedAt)
+	if soak < minSoak {
+		return &soakError{
+			s: fmt.Sprintf("Build %d was published in channel '%s' %s ago, release to '%s' requires %s", sourceBuild.Build, sourceChannel, soak, targetChannel, minSoak),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/experimental-platform/release-tagger/git"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+var testPolicyJSON = `{
+  "promotions": [
+    {"from": "source", "to": "tgt"},
+    {"from": "beta", "to": "stable", "min_soak": "72h", "approvals": 2}
+  ]
+}`
+
+func writeTestPolicy(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "tagger-policy")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	assert.Nil(t, err)
+
+	return file.Name()
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policyFile := writeTestPolicy(t, testPolicyJSON)
+	defer os.Remove(policyFile)
+
+	policy, err := loadPolicy(policyFile)
+	assert.Nil(t, err)
+
+	assert.Nil(t, policy.check("beta", "stable", []string{"alice", "bob"}))
+
+	// skipping beta
+	assert.NotNil(t, policy.check("development", "stable", []string{"alice", "bob"}))
+
+	// the same approver counts once
+	assert.NotNil(t, policy.check("beta", "stable", []string{"alice", "alice"}))
+}
+
+func TestSoakTime(t *testing.T) {
+	policyFile := writeTestPolicy(t, `{
+  "promotions": [{"from": "beta", "to": "stable", "min_soak": "72h"}, {"from": "alpha", "to": "stable"}],
+  "channels": {"stable": {"min_soak": "24h"}}
+}`)
+	defer os.Remove(policyFile)
+
+	policy, err := loadPolicy(policyFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 72*time.Hour, policy.requiredSoak("beta", "stable"))
+	assert.Equal(t, 24*time.Hour, policy.requiredSoak("alpha", "stable"))
+	assert.Equal(t, time.Duration(0), policy.requiredSoak("alpha", "beta"))
+
+	betaBuild := git.BuildsDatum{Build: 12, PublishedAt: "2016-08-24T14:02:38Z"}
+	releaseTime := time.Date(2016, 8, 28, 12, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, checkSoak("beta", betaBuild, "stable", releaseTime, 72*time.Hour))
+
+	err = checkSoak("beta", betaBuild, "stable", releaseTime.Add(-24*time.Hour), 72*time.Hour)
+	assert.IsType(t, &soakError{}, err)
+	assert.Equal(t, "Build 12 was published in channel 'beta' 69h57m22s ago, release to 'stable' requires 72h0m0s", err.Error())
+}
+
+func TestForcedReleaseCommitMessage(t *testing.T) {
+	policyFile := writeTestPolicy(t, `{"promotions": [{"from": "source", "to": "tgt", "min_soak": "720h"}]}`)
+	defer os.Remove(policyFile)
+
+	opts := taggerOptions{
+		Policy: policyFile,
+		Args: taggerOptionsArgs{
+			Action:        "create",
+			SourceChannel: "source",
+			TargetChannel: "tgt",
+		},
+	}
+	sourceBuild := git.BuildsDatum{Build: 12, PublishedAt: "2016-08-24T14:02:38Z"}
+
+	_, err := checkPolicy(opts, sourceBuild, "2016-09-01T12:00:00Z")
+	assert.IsType(t, &soakError{}, err)
+
+	opts.Force = true
+	overrides, err := checkPolicy(opts, sourceBuild, "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+	assert.Len(t, overrides, 1)
+
+	expected := "release on channel 'tgt' at 2016-09-01T12:00:00Z\n\nForced despite:\n* " + overrides[0] + "\n"
+	assert.Equal(t, expected, releaseCommitMessage([]string{"tgt"}, "2016-09-01T12:00:00Z", overrides))
+}
+
+func TestLoadPolicyInvalid(t *testing.T) {
+	policyFile := writeTestPolicy(t, `{"promotions": [{"from": "beta", "to": "stable", "min_soak": "three days"}]}`)
+	defer os.Remove(policyFile)
+
+	_, err := loadPolicy(policyFile)
+	assert.NotNil(t, err)
+}
+
+func TestUpdateJSONEnforcesPolicy(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	policyFile := writeTestPolicy(t, testPolicyJSON)
+	defer os.Remove(policyFile)
+
+	srcJSONPath := path.Join(repo.GetDirectory(), "source.json")
+	ioutil.WriteFile(srcJSONPath, []byte(testOldJSON), 0644)
+
+	opts := taggerOptions{
+		Policy: policyFile,
+		Args: taggerOptionsArgs{
+			Action:        "create",
+			SourceChannel: "source",
+			TargetChannel: "stable",
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+
+	_, err = repo.DumpChannel("stable")
+	assert.True(t, os.IsNotExist(err), "Channel must not be written when the policy refuses the release")
+
+	opts.Args.TargetChannel = "tgt"
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+type imagePrune struct {
+	Image  string   `json:"image"`
+	Keep   []string `json:"keep"`
+	Delete []string `json:"delete"`
+}
+
+// referencedTags returns per image the tags used by any channel, now or in its last keepHistory revisions
+func referencedTags(repo *git.BuildsRepo, keepHistory int) (map[string]map[string]bool, error) {
+	channels, err := repo.ListChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]map[string]bool)
+	addBuilds := func(builds git.BuildsData) {
+		for _, datum := range builds {
+			for image, tag := range datum.Images {
+				if referenced[image] == nil {
+					referenced[image] = make(map[string]bool)
+				}
+				referenced[image][tag] = true
+			}
+		}
+	}
+
+	for _, channel := range channels {
+		builds, err := repo.LoadChannel(channel)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load build data from channel '%s': %s", channel, err.Error())
+		}
+		addBuilds(builds)
+
+		history, err := repo.ChannelHistory(channel)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read history of channel '%s': %s", channel, err.Error())
+		}
+
+		for i := 0; i < len(history) && i < keepHistory; i++ {
+			addBuilds(history[i].Builds)
+		}
+	}
+
+	// CI pushes every image with its channel as tag, these are the sources of all releases
+	for image := range referenced {
+		for _, channel := range channels {
+			referenced[image][channel] = true
+		}
+	}
+
+	return referenced, nil
+}
+
+// planPrune decides which active tags of every image used by the channels are deleted
+func planPrune(repo *git.BuildsRepo, keepHistory, keepNewest int) ([]imagePrune, error) {
+	referenced, err := referencedTags(repo, keepHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for image := range referenced {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	plan := []imagePrune{}
+	for _, imageFullName := range images {
+		// images copied to other registries are not managed here
+		if _, _, _, err := parseImageName(imageFullName); err != nil {
+			log.Printf("Not pruning image '%s': %s", imageFullName, err.Error())
+			continue
+		}
+
+		result, err := planImagePrune(imageFullName, referenced[imageFullName], keepNewest)
+		if err != nil {
+			return nil, withExitCode(exitRegistry, err)
+		}
+
+		plan = append(plan, result)
+	}
+
+	return plan, nil
+}
+
+// planImagePrune keeps the newest and the referenced active tags of an image and deletes all others.
+// Signature tags are kept as long as the image they sign is kept.
+func planImagePrune(imageFullName string, referenced map[string]bool, keepNewest int) (imagePrune, error) {
+	result := imagePrune{Image: imageFullName, Keep: []string{}, Delete: []string{}}
+
+	org, image, token, err := parseImageName(imageFullName)
+	if err != nil {
+		return result, err
+	}
+
+	tags, err := getImageTags(image, org, token)
+	if err != nil {
+		return result, fmt.Errorf("Failed to list tags of image '%s': %s", imageFullName, err.Error())
+	}
+
+	var active, signatures []quayTagsResponseTag
+	for _, tag := range tags {
+		if tag.EndTs != nil {
+			continue
+		}
+
+		// signatures are never referenced by a channel, they go with their image
+		if strings.HasPrefix(tag.Name, "sha256-") && strings.HasSuffix(tag.Name, ".sig") {
+			signatures = append(signatures, tag)
+		} else {
+			active = append(active, tag)
+		}
+	}
+	sort.Sort(tagsByStart(active))
+	sort.Sort(tagsByStart(signatures))
+
+	kept := make(map[string]bool)
+	for i, tag := range active {
+		if i < keepNewest || referenced[tag.Name] {
+			result.Keep = append(result.Keep, tag.Name)
+			if tag.ManifestDigest != "" {
+				kept[signatureTag(tag.ManifestDigest)] = true
+			}
+		} else {
+			result.Delete = append(result.Delete, tag.Name)
+		}
+	}
+
+	for _, tag := range signatures {
+		if kept[tag.Name] {
+			result.Keep = append(result.Keep, tag.Name)
+		} else {
+			result.Delete = append(result.Delete, tag.Name)
+		}
+	}
+
+	return result, nil
+}
+
+func prune(repo *git.BuildsRepo, opts taggerOptions) error {
+	err := checkIfTokensPresent()
+	if err != nil {
+		return err
+	}
+
+	// tags used by the missing history would look unreferenced
+	shallow, err := repo.IsShallow()
+	if err != nil {
+		return err
+	}
+	if shallow && opts.Commit {
+		return withExitCode(exitUsage, errors.New("Refusing to delete tags based on a shallow clone of the builds repo, run prune without --depth"))
+	}
+	if shallow {
+		log.Printf("The builds repo is a shallow clone, tags used by older builds are missing from the plan")
+	}
+
+	plan, err := planPrune(repo, opts.KeepHistory, opts.KeepNewest)
+	if err != nil {
+		return err
+	}
+
+	if opts.Output == "json" {
+		err = printJSON(plan)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, result := range plan {
+			fmt.Printf("%s: keeping %d tags, deleting %d tags\n", result.Image, len(result.Keep), len(result.Delete))
+			for _, tag := range result.Delete {
+				fmt.Printf(" * %s\n", tag)
+			}
+		}
+	}
+
+	if opts.Commit == false {
+		log.Printf("Dry run. Use --commit to delete the tags.")
+		return nil
+	}
+
+	for _, result := range plan {
+		org, image, token, err := parseImageName(result.Image)
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range result.Delete {
+			err = deleteTag(image, org, tag, token)
+			if err != nil {
+				return withExitCode(exitRegistry, fmt.Errorf("Failed to delete tag '%s' of image '%s': %s", tag, result.Image, err.Error()))
+			}
+			log.Printf("Image '%s': deleted tag '%s'", result.Image, tag)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/experimental-platform/release-tagger/git"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func TestPlanImagePrune(t *testing.T) {
+	referenced := map[string]bool{"2016-05-25-0813": true, "soul3": true}
+
+	result, err := planImagePrune("quay.io/experimentalplatform/skvs", referenced, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"development", "2016-08-11-1153", "soul3", "2016-05-25-0813"}, result.Keep)
+	assert.Contains(t, result.Delete, "2016-05-18-1506")
+	assert.Contains(t, result.Delete, "releasetest")
+	// 40 tags are active, the expired ones are left alone
+	assert.Len(t, result.Delete, 36)
+}
+
+func TestPlanImagePruneKeepsSignatures(t *testing.T) {
+	result, err := planImagePrune("quay.io/experimentalplatform/signed", map[string]bool{}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"development", signatureTag(signedDigests[0])}, result.Keep)
+	assert.Equal(t, []string{"2016-08-01-1501", signatureTag(signedDigests[1]), signatureTag(signedDigests[2])}, result.Delete)
+}
+
+func TestReferencedTags(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("pruned", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "2016-08-11-1153",
+		},
+	}})
+	assert.Nil(t, err)
+
+	referenced, err := referencedTags(repo, 10)
+	assert.Nil(t, err)
+	assert.True(t, referenced["quay.io/experimentalplatform/skvs"]["2016-08-11-1153"])
+	assert.True(t, referenced["quay.io/experimentalplatform/skvs"]["pruned"], "Channel tags must be kept")
+}
+
+func TestPruneRefusesShallowClone(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("TOKEN_PROTONET", "foobar token")()
+
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	os.MkdirAll(path.Join(repo.GetDirectory(), ".git"), 0755)
+	ioutil.WriteFile(path.Join(repo.GetDirectory(), ".git", "shallow"), []byte("8b8cd46eeab0b530d0fcb64de26fe62fd68e736f\n"), 0644)
+
+	err = prune(repo, taggerOptions{Commit: true, KeepHistory: 10, KeepNewest: 2})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "shallow clone")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -6,14 +6,67 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 type quayTagsResponseTag struct {
-	Reversion     bool   `json:"reversion"`
-	StartTs       *int32 `json:"start_ts"`
-	EndTs         *int32 `json:"end_ts"`
-	Name          string `json:"name"`
-	DockerImageID string `json:"docker_image_id"`
+	Reversion      bool   `json:"reversion"`
+	StartTs        *int32 `json:"start_ts"`
+	EndTs          *int32 `json:"end_ts"`
+	Name           string `json:"name"`
+	DockerImageID  string `json:"docker_image_id"`
+	ManifestDigest string `json:"manifest_digest,omitempty"`
+}
+
+// tagImageID identifies the image of a tag by its manifest digest, or by its
+// docker image id for images pushed with the v1 registry API
+func tagImageID(t *quayTagsResponseTag) string {
+	if t.ManifestDigest != "" {
+		return t.ManifestDigest
+	}
+
+	return t.DockerImageID
+}
+
+// imageRef holds both ids of an image. Images pushed with the v1 registry API have no
+// manifest digest, builds released before manifest digests were recorded only have the
+// docker image id.
+type imageRef struct {
+	DockerImageID  string
+	ManifestDigest string
+}
+
+func tagImageRef(t *quayTagsResponseTag) imageRef {
+	return imageRef{DockerImageID: t.DockerImageID, ManifestDigest: t.ManifestDigest}
+}
+
+// String returns the manifest digest, or the docker image id if there is none, see tagImageID
+func (r imageRef) String() string {
+	if r.ManifestDigest != "" {
+		return r.ManifestDigest
+	}
+
+	return r.DockerImageID
+}
+
+// empty tells whether no id is known
+func (r imageRef) empty() bool {
+	return r.DockerImageID == "" && r.ManifestDigest == ""
+}
+
+// comparable tells whether both sides know the same kind of id
+func (r imageRef) comparable(other imageRef) bool {
+	return (r.ManifestDigest != "" && other.ManifestDigest != "") || (r.DockerImageID != "" && other.DockerImageID != "")
+}
+
+// same tells whether both refer to the same image, comparing the ids known on both sides
+func (r imageRef) same(other imageRef) bool {
+	if r.ManifestDigest != "" && other.ManifestDigest != "" {
+		return r.ManifestDigest == other.ManifestDigest
+	}
+
+	return r.DockerImageID != "" && r.DockerImageID == other.DockerImageID
 }
 
 type quayTagsResponse struct {
@@ -22,6 +75,18 @@ type quayTagsResponse struct {
 	Tags          []quayTagsResponseTag
 }
 
+// tagsByStart sorts tags newest first
+type tagsByStart []quayTagsResponseTag
+
+func (t tagsByStart) Len() int      { return len(t) }
+func (t tagsByStart) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t tagsByStart) Less(i, j int) bool {
+	if t[i].StartTs == nil || t[j].StartTs == nil {
+		return t[i].StartTs != nil
+	}
+	return *t[i].StartTs > *t[j].StartTs
+}
+
 type errorQuayTagNotFound struct {
 	s string
 }
@@ -76,20 +141,40 @@ func getImageTags(image, org, token string) ([]quayTagsResponseTag, error) {
 	return results, nil
 }
 
-func getTagImage(image, org, tag, token string) (string, error) {
+func getTagImage(image, org, tag, token string) (imageRef, error) {
 	tags, err := getImageTags(image, org, token)
 	if err != nil {
-		return "", nil
+		return imageRef{}, err
 	}
 
-	for _, t := range tags {
-		if t.EndTs == nil && t.Name == tag {
-			return t.DockerImageID, nil
-		}
+	t := findTag(tags, tag)
+	if t == nil || t.EndTs != nil {
+		return imageRef{}, newErrorQuayTagNotFound(tag, org, image)
 	}
 
-	return "", newErrorQuayTagNotFound(tag, org, image)
+	return tagImageRef(t), nil
+}
+
+// findTag returns the active entry of a tag, or its most recently expired
+// entry if the tag does not exist anymore, or nil if it never existed
+func findTag(tags []quayTagsResponseTag, tag string) *quayTagsResponseTag {
+	var found *quayTagsResponseTag
+
+	for i, t := range tags {
+		if t.Name != tag {
+			continue
+		}
+
+		if t.EndTs == nil {
+			return &tags[i]
+		}
+
+		if found == nil || *t.EndTs > *found.EndTs {
+			found = &tags[i]
+		}
+	}
 
+	return found
 }
 
 func setTagImage(image, org, tag, imageID, token string) error {
@@ -115,3 +200,99 @@ func setTagImage(image, org, tag, imageID, token string) error {
 
 	return nil
 }
+
+func deleteTag(image, org, tag, token string) error {
+	url := fmt.Sprintf("https://quay.io/api/v1/repository/%s/%s/tag/%s", org, image, tag)
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 204 {
+		return errors.New(resp.Status)
+	}
+
+	return nil
+}
+
+// getTagHistory returns every image a tag ever pointed to, oldest first
+func getTagHistory(image, org, tag, token string) ([]quayTagsResponseTag, error) {
+	tags, err := getImageTags(image, org, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []quayTagsResponseTag
+	for _, t := range tags {
+		if t.Name == tag {
+			history = append(history, t)
+		}
+	}
+
+	if len(history) == 0 {
+		return nil, newErrorQuayTagNotFound(tag, org, image)
+	}
+
+	sort.Sort(sort.Reverse(tagsByStart(history)))
+
+	return history, nil
+}
+
+// restoreTag points a tag back to an image it pointed to before, or recreates a deleted tag.
+// The image is given as returned by tagImageID.
+func restoreTag(image, org, tag, imageID, token string) error {
+	url := fmt.Sprintf("https://quay.io/api/v1/repository/%s/%s/tag/%s/restore", org, image, tag)
+	var jsonStr = fmt.Sprintf(`{"image":"%s"}`, imageID)
+	if strings.HasPrefix(imageID, "sha256:") {
+		jsonStr = fmt.Sprintf(`{"manifest_digest":"%s"}`, imageID)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(jsonStr)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errors.New(resp.Status)
+	}
+
+	return nil
+}
+
+// restorePreviousTagImage points a tag back to the image it pointed to before the current one,
+// or recreates a deleted tag, and returns the id of the restored image
+func restorePreviousTagImage(image, org, tag, token string) (string, error) {
+	history, err := getTagHistory(image, org, tag, token)
+	if err != nil {
+		return "", err
+	}
+
+	previous := len(history) - 1
+	if history[previous].EndTs == nil {
+		// the tag exists, go back one entry
+		previous--
+	}
+
+	if previous < 0 {
+		return "", fmt.Errorf("Tag '%s' of image '%s/%s' never pointed to another image", tag, org, image)
+	}
+
+	imageID := tagImageID(&history[previous])
+	return imageID, restoreTag(image, org, tag, imageID, token)
+}
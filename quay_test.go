@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"testing"
 
 	"gopkg.in/stretchr/testify.v1/assert"
@@ -81,9 +82,108 @@ func getMux() *http.ServeMux {
 
 	}))
 
+	mux.Handle("/api/v1/repository/experimentalplatform/skvs/tag/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" {
+			w.WriteHeader(204)
+		} else if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/restore") {
+			var payload struct {
+				Image          string `json:"image"`
+				ManifestDigest string `json:"manifest_digest"`
+			}
+
+			decoder := json.NewDecoder(r.Body)
+			err := decoder.Decode(&payload)
+			if err != nil || (len(payload.Image) != 64 && len(payload.ManifestDigest) != 71) {
+				w.WriteHeader(400)
+				return
+			}
+
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{}`)
+		} else {
+			w.WriteHeader(405)
+		}
+	}))
+
+	listDigest := manifestDigest([]byte(multiArchList))
+
+	mux.Handle("/api/v1/repository/experimentalplatform/multiarch/tag", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		fmt.Fprintf(w, `{"has_additional": false, "page": 1, "tags": [{"reversion": false, "start_ts": 1470957215, "name": "development", "docker_image_id": "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", "manifest_digest": "%s"}]}`, listDigest)
+	}))
+
+	// the signed image has a cosign signature tag next to every pushed image, and one left over
+	mux.Handle("/api/v1/repository/experimentalplatform/signed/tag", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tags []string
+		for i, tag := range []struct{ name, digest string }{
+			{"development", signedDigests[0]},
+			{signatureTag(signedDigests[0]), manifestDigest([]byte("signature 0"))},
+			{"2016-08-01-1501", signedDigests[1]},
+			{signatureTag(signedDigests[1]), manifestDigest([]byte("signature 1"))},
+			{signatureTag(signedDigests[2]), manifestDigest([]byte("signature 2"))},
+		} {
+			tags = append(tags, fmt.Sprintf(`{"reversion": false, "start_ts": %d, "name": "%s", "docker_image_id": "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", "manifest_digest": "%s"}`, 1470957215-i*1000, tag.name, tag.digest))
+		}
+
+		w.WriteHeader(200)
+		fmt.Fprintf(w, `{"has_additional": false, "page": 1, "tags": [%s]}`, strings.Join(tags, ", "))
+	}))
+
+	legacyTagHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/tag"):
+			w.WriteHeader(200)
+			fmt.Fprintf(w, `{"has_additional": false, "page": 1, "tags": [{"reversion": false, "start_ts": 1470957215, "name": "development", "docker_image_id": "%s", "manifest_digest": "%s"}]}`, legacyImageID, legacyDigest)
+		case r.Method == "PUT":
+			var payload struct {
+				Image string `json:"image"`
+			}
+			json.NewDecoder(r.Body).Decode(&payload)
+
+			legacyTags.Lock()
+			legacyTags.m[path.Base(r.URL.Path)] = payload.Image
+			legacyTags.Unlock()
+			w.WriteHeader(201)
+		default:
+			w.WriteHeader(405)
+		}
+	})
+	mux.Handle("/api/v1/repository/experimentalplatform/legacy/tag", legacyTagHandler)
+	mux.Handle("/api/v1/repository/experimentalplatform/legacy/tag/", legacyTagHandler)
+
+	// the v2 registries are served next to the Quay API
+	handleTestRegistries(mux)
+
 	return mux
 }
 
+// signedDigests are the manifest digests of the images tagged for the signed image, the last one is gone
+var signedDigests = []string{
+	manifestDigest([]byte("signed image 0")),
+	manifestDigest([]byte("signed image 1")),
+	manifestDigest([]byte("signed image 2")),
+}
+
+// legacyTags records the docker image ids tagged through the Quay API for the legacy image
+var legacyTags = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// setTestEnv sets an environment variable and returns a function restoring its previous value
+func setTestEnv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	server := httptest.NewServer(getMux())
 	u, _ := url.Parse(server.URL)
@@ -96,7 +196,7 @@ func TestMain(m *testing.M) {
 func TestGetTagImage(t *testing.T) {
 	id, err := getTagImage("skvs", "experimentalplatform", "development", "foobar token")
 	assert.Nil(t, err)
-	assert.Equal(t, "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", id)
+	assert.Equal(t, imageRef{DockerImageID: "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f"}, id)
 }
 
 func TestGetTagImage2(t *testing.T) {
@@ -111,3 +211,75 @@ func TestSetTagImage(t *testing.T) {
 	err := setTagImage("skvs", "experimentalplatform", tag, "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", "foobar token")
 	assert.Nil(t, err)
 }
+
+func TestGetTagImageError(t *testing.T) {
+	_, err := getTagImage("no-such-image", "experimentalplatform", "development", "foobar token")
+	assert.NotNil(t, err)
+}
+
+func TestFindTag(t *testing.T) {
+	tags, err := getImageTags("skvs", "experimentalplatform", "foobar token")
+	assert.Nil(t, err)
+
+	tag := findTag(tags, "soul3")
+	assert.NotNil(t, tag)
+	assert.Nil(t, tag.EndTs)
+	assert.Equal(t, "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb", tag.DockerImageID)
+
+	assert.Nil(t, findTag(tags, "no-such-tag"))
+
+	// expired tags are still found
+	expired := []quayTagsResponseTag{}
+	for _, tag := range tags {
+		if tag.Name == "soul3" && tag.EndTs != nil {
+			expired = append(expired, tag)
+		}
+	}
+	tag = findTag(expired, "soul3")
+	assert.NotNil(t, tag)
+	assert.Equal(t, int32(1470669097), *tag.EndTs)
+}
+
+func TestDeleteTag(t *testing.T) {
+	err := deleteTag("skvs", "experimentalplatform", "2016-04-25", "foobar token")
+	assert.Nil(t, err)
+
+	err = deleteTag("no-such-image", "experimentalplatform", "2016-04-25", "foobar token")
+	assert.NotNil(t, err)
+}
+
+func TestGetTagHistory(t *testing.T) {
+	history, err := getTagHistory("skvs", "experimentalplatform", "soul3", "foobar token")
+	assert.Nil(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, "fa8b4fbd3d564be568052d1b236a7acdcf126f8e44efe333a7bfb27df197dd45", history[0].DockerImageID)
+	assert.Equal(t, "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb", history[2].DockerImageID)
+	assert.Nil(t, history[2].EndTs)
+
+	_, err = getTagHistory("skvs", "experimentalplatform", "no-such-tag", "foobar token")
+	assert.IsType(t, &errorQuayTagNotFound{}, err)
+}
+
+func TestRestorePreviousTagImage(t *testing.T) {
+	id, err := restorePreviousTagImage("skvs", "experimentalplatform", "soul3", "foobar token")
+	assert.Nil(t, err)
+	assert.Equal(t, "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc", id)
+
+	// the tag has always pointed to the same image
+	_, err = restorePreviousTagImage("skvs", "experimentalplatform", "hh", "foobar token")
+	assert.NotNil(t, err)
+}
+
+func TestImageRef(t *testing.T) {
+	v1 := imageRef{DockerImageID: "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f"}
+	v2 := imageRef{DockerImageID: v1.DockerImageID, ManifestDigest: "sha256:9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623"}
+	mirrored := imageRef{ManifestDigest: v2.ManifestDigest}
+
+	assert.True(t, v1.same(v2), "builds recorded before manifest digests match by docker image id")
+	assert.True(t, v2.same(mirrored))
+	assert.False(t, v1.same(mirrored))
+	assert.False(t, v1.comparable(mirrored))
+	assert.False(t, imageRef{}.same(imageRef{}))
+	assert.Equal(t, v2.ManifestDigest, v2.String())
+	assert.Equal(t, v1.DockerImageID, v1.String())
+}
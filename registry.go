@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	mediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+
+	// legacy schema1 manifests, their digests do not cover the embedded signatures
+	mediaTypeSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	mediaTypeSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// manifestMediaTypes are accepted when fetching manifests, listing the multi-arch
+// types makes the registry return manifest lists and indexes unchanged
+var manifestMediaTypes = []string{mediaTypeManifestList, mediaTypeOCIIndex, mediaTypeManifest, mediaTypeOCIManifest}
+
+// registryClient talks to the docker registry v2 API. Quay accepts its API tokens
+// as password of the '$oauthtoken' user when handing out registry tokens.
+type registryClient struct {
+	registry string
+	username string
+	password string
+	token    string
+	basic    bool
+}
+
+type registryManifest struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+type errorManifestNotFound struct {
+	s string
+}
+
+func (e *errorManifestNotFound) Error() string {
+	return e.s
+}
+
+func newRegistryClient(registry, token string) *registryClient {
+	return &registryClient{
+		registry: registry,
+		username: "$oauthtoken",
+		password: token,
+	}
+}
+
+func manifestDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// parseBearerChallenge parses a 'WWW-Authenticate: Bearer realm="...",service="..."' header
+func parseBearerChallenge(header string) (map[string]string, error) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, fmt.Errorf("Unsupported registry authentication '%s'", header)
+	}
+
+	params := make(map[string]string)
+	rest := strings.TrimPrefix(header, "Bearer ")
+	for len(rest) > 0 {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("Malformed registry authentication '%s'", header)
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		params[key] = value
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+
+	if params["realm"] == "" {
+		return nil, fmt.Errorf("Missing realm in registry authentication '%s'", header)
+	}
+
+	return params, nil
+}
+
+// authenticate fetches a bearer token as requested by the registry's challenge,
+// or switches to basic authentication
+func (c *registryClient) authenticate(challenge string) error {
+	if strings.HasPrefix(challenge, "Basic") {
+		c.basic = true
+		return nil
+	}
+
+	params, err := parseBearerChallenge(challenge)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Failed to authenticate at registry '%s': %s", c.registry, resp.Status)
+	}
+
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	err = decoder.Decode(&tokenResponse)
+	if err != nil {
+		return err
+	}
+
+	c.token = tokenResponse.Token
+	if c.token == "" {
+		c.token = tokenResponse.AccessToken
+	}
+
+	return nil
+}
+
+// url resolves a path below the registry's API root, or a location returned by the registry
+func (c *registryClient) url(location string) (string, error) {
+	base, err := url.Parse(fmt.Sprintf("https://%s/v2/", c.registry))
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
+
+// send makes a single request with the current credentials
+func (c *registryClient) send(method, location string, body io.Reader, length int64, header http.Header) (*http.Response, error) {
+	u, err := c.url(location)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = length
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.basic {
+		req.SetBasicAuth(c.username, c.password)
+	} else if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// do sends a request to the registry, authenticating and retrying once if the registry asks for it
+func (c *registryClient) do(method, location string, body []byte, header http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(method, location, bytes.NewReader(body), int64(len(body)), header)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 401 || attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		err = c.authenticate(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getManifest fetches a manifest by tag or digest without converting it
+func (c *registryClient) getManifest(repository, reference string) (*registryManifest, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := c.do("GET", fmt.Sprintf("%s/manifests/%s", repository, reference), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, &errorManifestNotFound{s: fmt.Sprintf("Failed to find manifest '%s' of '%s'", reference, repository)}
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &registryManifest{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    manifestDigest(body),
+		Body:      body,
+	}
+
+	if strings.HasPrefix(reference, "sha256:") && manifest.Digest != reference {
+		return nil, fmt.Errorf("Registry returned manifest '%s' instead of '%s'", manifest.Digest, reference)
+	}
+
+	return manifest, nil
+}
+
+// manifestMediaType returns the media type of a manifest, which may be a legacy schema1 manifest
+func (c *registryClient) manifestMediaType(repository, reference string) (string, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join(append(manifestMediaTypes, mediaTypeSchema1Signed, mediaTypeSchema1), ", "))
+
+	resp, err := c.do("HEAD", fmt.Sprintf("%s/manifests/%s", repository, reference), nil, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return "", &errorManifestNotFound{s: fmt.Sprintf("Failed to find manifest '%s' of '%s'", reference, repository)}
+	}
+
+	if resp.StatusCode != 200 {
+		return "", errors.New(resp.Status)
+	}
+
+	// the media type may come with parameters like a charset
+	return strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]), nil
+}
+
+// supportedManifestType tells whether manifests of the media type can be copied and retagged by digest
+func supportedManifestType(mediaType string) bool {
+	for _, supported := range manifestMediaTypes {
+		if mediaType == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+// putManifest uploads a manifest under the given tag and makes sure the registry stored it unchanged
+func (c *registryClient) putManifest(repository, reference string, manifest *registryManifest) error {
+	header := http.Header{}
+	header.Set("Content-Type", manifest.MediaType)
+
+	resp, err := c.do("PUT", fmt.Sprintf("%s/manifests/%s", repository, reference), manifest.Body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		return errors.New(resp.Status)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest != "" && digest != manifest.Digest {
+		return fmt.Errorf("Registry stored manifest '%s' instead of '%s'", digest, manifest.Digest)
+	}
+
+	return nil
+}
+
+// tagManifest points the tag to the manifest with the given digest. The manifest is put back
+// byte for byte, so manifest lists and OCI indexes keep all their platforms.
+func (c *registryClient) tagManifest(repository, digest, tag string) error {
+	manifest, err := c.getManifest(repository, digest)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch manifest '%s' of '%s': %s", digest, repository, err.Error())
+	}
+
+	err = c.putManifest(repository, tag, manifest)
+	if err != nil {
+		return fmt.Errorf("Failed to tag manifest '%s' of '%s' as '%s': %s", digest, repository, tag, err.Error())
+	}
+
+	return nil
+}
+
+// blobExists checks whether the repository already contains the blob
+func (c *registryClient) blobExists(repository, digest string) (bool, error) {
+	resp, err := c.do("HEAD", fmt.Sprintf("%s/blobs/%s", repository, digest), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, errors.New(resp.Status)
+	}
+}
+
+// startUpload starts a blob upload, trying to mount the blob from another repository of the
+// same registry if mountFrom is given. It returns an empty location if the blob was mounted.
+func (c *registryClient) startUpload(repository, digest, mountFrom string) (string, error) {
+	location := fmt.Sprintf("%s/blobs/uploads/", repository)
+	if mountFrom != "" {
+		location += "?" + url.Values{"mount": {digest}, "from": {mountFrom}}.Encode()
+	}
+
+	resp, err := c.do("POST", location, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 201:
+		return "", nil
+	case 202:
+		return resp.Header.Get("Location"), nil
+	default:
+		return "", errors.New(resp.Status)
+	}
+}
+
+// getBlob opens a blob for streaming, the caller closes it
+func (c *registryClient) getBlob(repository, digest string) (io.ReadCloser, int64, error) {
+	resp, err := c.do("GET", fmt.Sprintf("%s/blobs/%s", repository, digest), nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, 0, errors.New(resp.Status)
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
+
+// finishUpload streams the blob into an upload started by startUpload
+func (c *registryClient) finishUpload(location, digest string, body io.Reader, length int64) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.send("PUT", u.String(), body, length, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		return errors.New(resp.Status)
+	}
+
+	return nil
+}
+
+// uploadBlob uploads the data as a blob unless the repository already contains it
+func (c *registryClient) uploadBlob(repository string, data []byte) error {
+	digest := manifestDigest(data)
+
+	exists, err := c.blobExists(repository, digest)
+	if err != nil || exists {
+		return err
+	}
+
+	location, err := c.startUpload(repository, digest, "")
+	if err != nil {
+		return err
+	}
+
+	return c.finishUpload(location, digest, bytes.NewReader(data), int64(len(data)))
+}
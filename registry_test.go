@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+// handleTestRegistries serves the stand-in v2 registries of Quay and of the mirrors
+func handleTestRegistries(mux *http.ServeMux) {
+	// the legacy image only has a schema1 manifest, whose digest does not match its signed body
+	mux.Handle("/v2/experimentalplatform/legacy/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer registry token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="https://quay.io/v2/auth",service="quay.io",scope="repository:experimentalplatform/legacy:pull,push"`)
+			w.WriteHeader(401)
+			return
+		}
+
+		if (r.Method != "HEAD" && r.Method != "GET") || path.Base(r.URL.Path) != legacyDigest {
+			w.WriteHeader(405)
+			return
+		}
+
+		w.Header().Set("Content-Type", mediaTypeSchema1Signed)
+		w.Header().Set("Docker-Content-Digest", legacyDigest)
+		w.WriteHeader(200)
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"schemaVersion":1,"name":"experimentalplatform/legacy","tag":"development","signatures":[]}`)
+		}
+	}))
+
+	mux.Handle("/v2/auth", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		scope := r.FormValue("scope")
+		if !ok || user != "$oauthtoken" || password != "foobar token" || (scope != "repository:experimentalplatform/multiarch:pull,push" && scope != "repository:experimentalplatform/legacy:pull,push") {
+			w.WriteHeader(401)
+			return
+		}
+
+		w.WriteHeader(200)
+		fmt.Fprintln(w, `{"token": "registry token"}`)
+	}))
+
+	mux.Handle("/v2/experimentalplatform/multiarch/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer registry token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="https://quay.io/v2/auth",service="quay.io",scope="repository:experimentalplatform/multiarch:pull,push"`)
+			w.WriteHeader(401)
+			return
+		}
+
+		reference := path.Base(r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+
+		registryLock.Lock()
+		defer registryLock.Unlock()
+
+		switch {
+		case r.Method == "HEAD" && strings.Contains(r.URL.Path, "/blobs/"):
+			if _, ok := registryBlobs[reference]; !ok {
+				w.WriteHeader(404)
+				return
+			}
+			w.WriteHeader(200)
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/blobs/"):
+			blob, ok := registryBlobs[reference]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+
+			w.WriteHeader(200)
+			fmt.Fprint(w, blob)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/blobs/uploads"):
+			w.Header().Set("Location", "/v2/experimentalplatform/multiarch/blobs/uploads/session")
+			w.WriteHeader(202)
+		case r.Method == "PUT" && strings.Contains(r.URL.Path, "/blobs/uploads/"):
+			if manifestDigest(body) != r.FormValue("digest") {
+				w.WriteHeader(400)
+				return
+			}
+			registryBlobs[r.FormValue("digest")] = string(body)
+			w.WriteHeader(201)
+		case r.Method == "GET" || r.Method == "HEAD":
+			manifest, ok := registryManifests[reference]
+			var content struct {
+				MediaType string `json:"mediaType"`
+			}
+			json.Unmarshal([]byte(manifest), &content)
+			mediaType := content.MediaType
+
+			// manifest lists are only served to clients accepting them
+			if !ok || !strings.Contains(r.Header.Get("Accept"), mediaType) {
+				w.WriteHeader(404)
+				return
+			}
+
+			w.Header().Set("Content-Type", mediaType)
+			w.Header().Set("Docker-Content-Digest", manifestDigest([]byte(manifest)))
+			w.WriteHeader(200)
+			fmt.Fprint(w, manifest)
+		case r.Method == "PUT" && r.Header.Get("Content-Type") == mediaTypeOCIManifest:
+			// signatures
+			registryManifests[reference] = string(body)
+			registryManifests[manifestDigest(body)] = string(body)
+
+			w.Header().Set("Docker-Content-Digest", manifestDigest(body))
+			w.WriteHeader(201)
+		case r.Method == "PUT":
+			if r.Header.Get("Content-Type") != mediaTypeManifestList && r.Header.Get("Content-Type") != mediaTypeManifest {
+				w.WriteHeader(400)
+				return
+			}
+
+			registryTags.Lock()
+			registryTags.m[reference] = manifestDigest(body)
+			registryTags.Unlock()
+
+			w.Header().Set("Docker-Content-Digest", manifestDigest(body))
+			w.WriteHeader(201)
+		default:
+			w.WriteHeader(405)
+		}
+	}))
+
+	mux.Handle("/v2/customer/multiarch/", mirrorRegistry.handler("/v2/customer/multiarch/"))
+	mux.Handle("/v2/mounted/multiarch/", mountRegistry.handler("/v2/mounted/multiarch/"))
+}
+
+// the stand-in v2 registry serves a manifest list of two platforms
+var (
+	registryLock      sync.Mutex
+	registryBlobs     = make(map[string]string)
+	registryManifests = make(map[string]string)
+	multiArchList     string
+)
+
+func init() {
+	var platforms []string
+	for _, arch := range []string{"amd64", "arm"} {
+		config := fmt.Sprintf(`{"architecture":"%s","os":"linux"}`, arch)
+		layer := fmt.Sprintf("root filesystem for %s", arch)
+		registryBlobs[manifestDigest([]byte(config))] = config
+		registryBlobs[manifestDigest([]byte(layer))] = layer
+
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":%d,"digest":"%s"},"layers":[{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","size":%d,"digest":"%s"}]}`,
+			mediaTypeManifest, len(config), manifestDigest([]byte(config)), len(layer), manifestDigest([]byte(layer)))
+		registryManifests[manifestDigest([]byte(manifest))] = manifest
+
+		platforms = append(platforms, fmt.Sprintf(`{"mediaType":"%s","size":%d,"digest":"%s","platform":{"architecture":"%s","os":"linux"}}`,
+			mediaTypeManifest, len(manifest), manifestDigest([]byte(manifest)), arch))
+	}
+
+	multiArchList = fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","manifests":[%s]}`, mediaTypeManifestList, strings.Join(platforms, ","))
+	registryManifests[manifestDigest([]byte(multiArchList))] = multiArchList
+
+	// the list is signed by CI
+	testSigningKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	listDigest := manifestDigest([]byte(multiArchList))
+	payload, _ := signaturePayload("quay.io/experimentalplatform/multiarch", listDigest)
+	signature, _ := signPayload(testSigningKey, payload)
+	registryBlobs[manifestDigest(payload)] = string(payload)
+	registryBlobs[manifestDigest([]byte("{}"))] = "{}"
+	registryManifests[signatureTag(listDigest)] = fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":2,"digest":"%s"},"layers":[{"mediaType":"%s","size":%d,"digest":"%s","annotations":{"%s":"%s"}}]}`,
+		mediaTypeOCIManifest, manifestDigest([]byte("{}")), mediaTypeSimpleSigning, len(payload), manifestDigest(payload), signatureAnnotation, signature)
+}
+
+var testSigningKey *ecdsa.PrivateKey
+
+const (
+	legacyImageID = "3ee5f4ed5108026902b32d2716ed7073e29b1dd0dde2aa124b357e23e5228c25"
+	legacyDigest  = "sha256:5b0b8d8e8f3e4a1c6d7e2f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c"
+)
+
+// registryTags records the manifest digests tagged in the stand-in v2 registry
+var registryTags = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// testMirror is a stand-in target registry with basic authentication, which only
+// accepts manifests whose blobs and platform manifests were uploaded before
+type testMirror struct {
+	sync.Mutex
+	blobs     map[string]string
+	manifests map[string]string
+	tags      map[string]string
+	mounted   int
+	streamed  int
+}
+
+var mirrorRegistry = &testMirror{blobs: map[string]string{}, manifests: map[string]string{}, tags: map[string]string{}}
+
+var mountRegistry = &testMirror{blobs: map[string]string{}, manifests: map[string]string{}, tags: map[string]string{}}
+
+func (m *testMirror) handler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "mirror" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mirror"`)
+			w.WriteHeader(401)
+			return
+		}
+
+		m.Lock()
+		defer m.Unlock()
+
+		reference := path.Base(r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+
+		switch {
+		case r.Method == "HEAD" && strings.Contains(r.URL.Path, "/blobs/"):
+			if _, ok := m.blobs[reference]; !ok {
+				w.WriteHeader(404)
+				return
+			}
+			w.WriteHeader(200)
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/blobs/"):
+			blob, ok := m.blobs[reference]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			w.WriteHeader(200)
+			fmt.Fprint(w, blob)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/blobs/uploads"):
+			registryLock.Lock()
+			blob, ok := registryBlobs[r.FormValue("mount")]
+			registryLock.Unlock()
+			if ok && r.FormValue("from") == "experimentalplatform/multiarch" {
+				m.blobs[r.FormValue("mount")] = blob
+				m.mounted++
+				w.WriteHeader(201)
+				return
+			}
+			w.Header().Set("Location", prefix+"blobs/uploads/session")
+			w.WriteHeader(202)
+		case r.Method == "PUT" && strings.Contains(r.URL.Path, "/blobs/uploads/"):
+			if manifestDigest(body) != r.FormValue("digest") {
+				w.WriteHeader(400)
+				return
+			}
+			m.blobs[r.FormValue("digest")] = string(body)
+			m.streamed++
+			w.WriteHeader(201)
+		case r.Method == "PUT" && strings.Contains(r.URL.Path, "/manifests/"):
+			var content struct {
+				Manifests []struct{ Digest string }
+				Config    struct{ Digest string }
+				Layers    []struct{ Digest string }
+			}
+			json.Unmarshal(body, &content)
+
+			for _, child := range content.Manifests {
+				if _, ok := m.manifests[child.Digest]; !ok {
+					w.WriteHeader(400)
+					return
+				}
+			}
+			for _, blob := range append(content.Layers, content.Config) {
+				if _, ok := m.blobs[blob.Digest]; blob.Digest != "" && !ok {
+					w.WriteHeader(400)
+					return
+				}
+			}
+
+			m.manifests[manifestDigest(body)] = string(body)
+			m.tags[reference] = manifestDigest(body)
+			w.Header().Set("Docker-Content-Digest", manifestDigest(body))
+			w.WriteHeader(201)
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/manifests/"):
+			digest, ok := m.tags[reference]
+			if !ok {
+				w.WriteHeader(404)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeManifestList)
+			w.WriteHeader(200)
+			fmt.Fprint(w, m.manifests[digest])
+		default:
+			w.WriteHeader(405)
+		}
+	})
+}
+
+func TestRegistryClientAuthentication(t *testing.T) {
+	client := newRegistryClient("quay.io", "wrong token")
+	_, err := client.getManifest("experimentalplatform/multiarch", manifestDigest([]byte(multiArchList)))
+	assert.NotNil(t, err)
+
+	client = newRegistryClient("quay.io", "foobar token")
+	manifest, err := client.getManifest("experimentalplatform/multiarch", manifestDigest([]byte(multiArchList)))
+	assert.Nil(t, err)
+	assert.Equal(t, mediaTypeManifestList, manifest.MediaType)
+	assert.Equal(t, multiArchList, string(manifest.Body))
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	params, err := parseBearerChallenge(`Bearer realm="https://quay.io/v2/auth",service="quay.io",scope="repository:experimentalplatform/skvs:pull,push"`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://quay.io/v2/auth",
+		"service": "quay.io",
+		"scope":   "repository:experimentalplatform/skvs:pull,push",
+	}, params)
+
+	_, err = parseBearerChallenge(`Basic realm="registry"`)
+	assert.NotNil(t, err)
+}
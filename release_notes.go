@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+const (
+	imageAdded   = "added"
+	imageUpdated = "updated"
+	imageRemoved = "removed"
+)
+
+type imageChange struct {
+	Image  string `json:"image"`
+	Change string `json:"change"`
+	OldTag string `json:"old_tag,omitempty"`
+	NewTag string `json:"new_tag,omitempty"`
+	OldID  string `json:"old_id,omitempty"`
+	NewID  string `json:"new_id,omitempty"`
+}
+
+// releaseNotes lists the images changed by a release compared to the channel's previous build
+type releaseNotes struct {
+	Channel       string        `json:"channel"`
+	SourceChannel string        `json:"source_channel"`
+	Build         int32         `json:"build"`
+	PreviousBuild int32         `json:"previous_build,omitempty"`
+	Codename      string        `json:"codename,omitempty"`
+	PublishedAt   string        `json:"published_at"`
+	Changes       []imageChange `json:"changes"`
+}
+
+// buildReleaseNotes compares the new build with the previous build of the channel, if any. Images whose
+// tags changed but are known to point to the same image id are left out.
+func buildReleaseNotes(channel, sourceChannel string, previous *git.BuildsDatum, next git.BuildsDatum) releaseNotes {
+	notes := releaseNotes{
+		Channel:       channel,
+		SourceChannel: sourceChannel,
+		Build:         next.Build,
+		Codename:      next.Codename,
+		PublishedAt:   next.PublishedAt,
+		Changes:       []imageChange{},
+	}
+
+	old := git.BuildsDatum{}
+	if previous != nil {
+		old = *previous
+		notes.PreviousBuild = previous.Build
+	}
+
+	images := make(map[string]bool)
+	for image := range old.Images {
+		images[image] = true
+	}
+	for image := range next.Images {
+		images[image] = true
+	}
+
+	var names []string
+	for image := range images {
+		names = append(names, image)
+	}
+	sort.Strings(names)
+
+	oldIDs, nextIDs := recordedImageRefs(old), recordedImageRefs(next)
+
+	for _, image := range names {
+		oldID, nextID := oldIDs[image], nextIDs[image]
+		change := imageChange{
+			Image:  image,
+			OldTag: old.Images[image],
+			NewTag: next.Images[image],
+			OldID:  oldID.String(),
+			NewID:  nextID.String(),
+		}
+
+		_, inOld := old.Images[image]
+		_, inNext := next.Images[image]
+		switch {
+		case !inOld:
+			change.Change = imageAdded
+		case !inNext:
+			change.Change = imageRemoved
+		case oldID.comparable(nextID):
+			if oldID.same(nextID) {
+				continue
+			}
+			change.Change = imageUpdated
+		case change.OldTag == change.NewTag:
+			continue
+		default:
+			change.Change = imageUpdated
+		}
+
+		notes.Changes = append(notes.Changes, change)
+	}
+
+	return notes
+}
+
+// shortID shortens image ids and manifest digests for display
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func (notes releaseNotes) markdown() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# %s build %d", notes.Channel, notes.Build)
+	if notes.Codename != "" {
+		fmt.Fprintf(&buf, " (%s)", notes.Codename)
+	}
+	fmt.Fprintf(&buf, "\n\nReleased %s from channel %s", notes.PublishedAt, notes.SourceChannel)
+	if notes.PreviousBuild != 0 {
+		fmt.Fprintf(&buf, ", previous build %d", notes.PreviousBuild)
+	}
+	fmt.Fprint(&buf, ".\n\n")
+
+	if len(notes.Changes) == 0 {
+		fmt.Fprint(&buf, "No images changed.\n")
+		return buf.String()
+	}
+
+	fmt.Fprint(&buf, "| Image | Change | Old tag | New tag | Old id | New id |\n")
+	fmt.Fprint(&buf, "|---|---|---|---|---|---|\n")
+	for _, change := range notes.Changes {
+		fmt.Fprintf(&buf, "| %s | %s | %s | %s | %s | %s |\n", change.Image, change.Change, change.OldTag, change.NewTag, shortID(change.OldID), shortID(change.NewID))
+	}
+
+	return buf.String()
+}
+
+// releaseNotesPath returns the path of the release notes, relative to the builds repo, without extension
+func releaseNotesPath(dir string, notes releaseNotes) string {
+	return path.Join(dir, fmt.Sprintf("%s-%d", notes.Channel, notes.Build))
+}
+
+// releaseNotesURL returns the link to the Markdown release notes in the builds repo
+func releaseNotesURL(baseURL, dir string, notes releaseNotes) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + releaseNotesPath(dir, notes) + ".md"
+}
+
+func checkReleaseNotesDir(dir string) error {
+	cleaned := path.Clean(dir)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("Release notes directory '%s' must be inside the builds repo", dir)
+	}
+
+	// JSON files in the root of the repo are channels
+	if cleaned == "." {
+		return fmt.Errorf("Release notes directory '%s' must not be the root of the builds repo", dir)
+	}
+
+	return nil
+}
+
+// releaseNotesFiles returns the JSON and the Markdown file of the release notes
+func releaseNotesFiles(dir string, notes releaseNotes) []string {
+	return []string{releaseNotesPath(dir, notes) + ".json", releaseNotesPath(dir, notes) + ".md"}
+}
+
+// writeReleaseNotes writes the Markdown and JSON release notes into the builds repo
+// and returns the written files relative to it
+func writeReleaseNotes(repo *git.BuildsRepo, dir string, notes releaseNotes) ([]string, error) {
+	err := os.MkdirAll(path.Join(repo.GetDirectory(), dir), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	names := releaseNotesFiles(dir, notes)
+	files := map[string][]byte{
+		names[0]: append(jsonData, '\n'),
+		names[1]: []byte(notes.markdown()),
+	}
+
+	var written []string
+	for fileName, data := range files {
+		err = ioutil.WriteFile(path.Join(repo.GetDirectory(), fileName), data, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to write release notes '%s': %s", fileName, err.Error())
+		}
+		written = append(written, fileName)
+	}
+	sort.Strings(written)
+
+	return written, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+const (
+	// the image was retagged
+	imageRetagged = "retagged"
+	// retagging the image failed
+	imageFailed = "failed"
+	// the image would be retagged without a dry run
+	imagePlanned = "planned"
+	// the target channel keeps its tag for the image, see --skip-unchanged
+	imageKept = "unchanged"
+)
+
+type imageResult struct {
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	// Channel is set for images kept unchanged in a single target channel
+	Channel string `json:"channel,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type channelResult struct {
+	Channel       string          `json:"channel"`
+	PreviousBuild int32           `json:"previous_build,omitempty"`
+	Build         int32           `json:"build"`
+	Tag           string          `json:"tag,omitempty"`
+	Data          git.BuildsDatum `json:"data"`
+	ReleaseNotes  []string        `json:"release_notes,omitempty"`
+}
+
+// releaseResult is the document printed by the copy and create actions with --output json
+type releaseResult struct {
+	Action        string          `json:"action"`
+	SourceChannel string          `json:"source_channel"`
+	SourceBuild   int32           `json:"source_build,omitempty"`
+	TagTimestamp  string          `json:"tag_timestamp"`
+	ISOTimestamp  string          `json:"iso_timestamp"`
+	DryRun        bool            `json:"dry_run"`
+	Overrides     []string        `json:"overrides,omitempty"`
+	Channels      []channelResult `json:"channels"`
+	Images        []imageResult   `json:"images"`
+	CommitID      string          `json:"commit,omitempty"`
+	Pushed        bool            `json:"pushed"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func newReleaseResult(opts taggerOptions, tagTimestamp, isoTimestamp string) *releaseResult {
+	return &releaseResult{
+		Action:        opts.Args.Action,
+		SourceChannel: opts.Args.SourceChannel,
+		TagTimestamp:  tagTimestamp,
+		ISOTimestamp:  isoTimestamp,
+		DryRun:        !opts.Commit,
+		Channels:      []channelResult{},
+		Images:        []imageResult{},
+	}
+}
+
+// addRetagResults records the outcome of retagging the images with the tag
+func (r *releaseResult) addRetagResults(images map[string]string, tag string, ids map[string]imageRef, err error) {
+	failed, _ := err.(retagErrors)
+
+	for image := range images {
+		result := imageResult{Image: image, Tag: tag, ID: ids[image].String(), Status: imageRetagged}
+
+		switch {
+		case ids == nil && err == nil:
+			result.Status = imagePlanned
+		case failed[image] != nil:
+			result.Status = imageFailed
+			result.Error = failed[image].Error()
+		}
+
+		r.Images = append(r.Images, result)
+	}
+}
+
+func (r *releaseResult) print() error {
+	sort.Sort(imageResultsByName(r.Images))
+
+	return printJSON(r)
+}
+
+type imageResultsByName []imageResult
+
+func (s imageResultsByName) Len() int      { return len(s) }
+func (s imageResultsByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s imageResultsByName) Less(i, j int) bool {
+	if s[i].Image != s[j].Image {
+		return s[i].Image < s[j].Image
+	}
+	if s[i].Tag != s[j].Tag {
+		return s[i].Tag < s[j].Tag
+	}
+	return s[i].Channel < s[j].Channel
+}
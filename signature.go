@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"strings"
+)
+
+const (
+	mediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+	signatureAnnotation    = "dev.cosignproject.cosign/signature"
+	signatureType          = "cosign container image signature"
+)
+
+// simpleSigningPayload is the signed document of a cosign signature
+type simpleSigningPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// signatureTag is the tag cosign stores the signatures of a manifest digest under
+func signatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+func loadVerificationKey(keyPath string) (*ecdsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read public key '%s': %s", keyPath, err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("File '%s' does not contain a PEM encoded public key", keyPath)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key '%s': %s", keyPath, err.Error())
+	}
+
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Public key '%s' is not an ECDSA key", keyPath)
+	}
+
+	return ecdsaKey, nil
+}
+
+// verifyPayload checks the signature of a simple signing payload and that it signs the digest
+func verifyPayload(payload []byte, signature string, digest string, key *ecdsa.PublicKey) error {
+	der, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("Malformed signature: %s", err.Error())
+	}
+
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(der, &sig)
+	if err != nil || len(rest) > 0 || sig.R == nil || sig.S == nil {
+		return errors.New("Malformed signature")
+	}
+
+	hash := sha256.Sum256(payload)
+	if !ecdsa.Verify(key, hash[:], sig.R, sig.S) {
+		return errors.New("Signature does not match the public key")
+	}
+
+	var document simpleSigningPayload
+	err = json.Unmarshal(payload, &document)
+	if err != nil {
+		return fmt.Errorf("Malformed signature payload: %s", err.Error())
+	}
+
+	if document.Critical.Type != signatureType {
+		return fmt.Errorf("Unknown signature type '%s'", document.Critical.Type)
+	}
+
+	if document.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("Signature is for '%s'", document.Critical.Image.DockerManifestDigest)
+	}
+
+	return nil
+}
+
+// verifyImageSignature succeeds if any signature stored for the digest is valid for the key
+func verifyImageSignature(client *registryClient, repository, digest string, key *ecdsa.PublicKey) error {
+	manifest, err := client.getManifest(repository, signatureTag(digest))
+	if err != nil {
+		return fmt.Errorf("Failed to fetch signatures of '%s@%s': %s", repository, digest, err.Error())
+	}
+
+	var content struct {
+		Layers []struct {
+			MediaType   string            `json:"mediaType"`
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+
+	err = json.Unmarshal(manifest.Body, &content)
+	if err != nil {
+		return fmt.Errorf("Failed to parse signatures of '%s@%s': %s", repository, digest, err.Error())
+	}
+
+	lastErr := errors.New("No signatures found")
+	for _, layer := range content.Layers {
+		if layer.MediaType != mediaTypeSimpleSigning {
+			continue
+		}
+
+		body, _, err := client.getBlob(repository, layer.Digest)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		payload, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if manifestDigest(payload) != layer.Digest {
+			lastErr = fmt.Errorf("Signature payload does not match its digest '%s'", layer.Digest)
+			continue
+		}
+
+		lastErr = verifyPayload(payload, layer.Annotations[signatureAnnotation], digest, key)
+		if lastErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No valid signature for '%s@%s': %s", repository, digest, lastErr.Error())
+}
+
+func loadSigningKey(keyPath string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read private key '%s': %s", keyPath, err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("File '%s' does not contain a PEM encoded private key", keyPath)
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse private key '%s': %s", keyPath, err.Error())
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse private key '%s': %s", keyPath, err.Error())
+		}
+		if ecdsaKey, ok := key.(*ecdsa.PrivateKey); ok {
+			return ecdsaKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("File '%s' does not contain an unencrypted ECDSA private key", keyPath)
+}
+
+// signaturePayload returns the simple signing document cosign signs for an image digest
+func signaturePayload(imageFullName, digest string) ([]byte, error) {
+	var document simpleSigningPayload
+	document.Critical.Identity.DockerReference = imageFullName
+	document.Critical.Image.DockerManifestDigest = digest
+	document.Critical.Type = signatureType
+
+	return json.Marshal(document)
+}
+
+// signPayload returns the base64 encoded ASN.1 ECDSA signature of the payload's SHA256 hash
+func signPayload(key *ecdsa.PrivateKey, payload []byte) (string, error) {
+	hash := sha256.Sum256(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	der, err := asn1.Marshal(ecdsaSignature{R: r, S: s})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// signImage adds a signature of the digest to the signatures stored in the repository,
+// unless the digest is already signed by the key
+func signImage(client *registryClient, repository, imageFullName, digest string, key *ecdsa.PrivateKey) error {
+	// promoting the same image again must not add another layer every time
+	if verifyImageSignature(client, repository, digest, &key.PublicKey) == nil {
+		log.Printf("Image '%s': '%s' is already signed by the key", imageFullName, digest)
+		return nil
+	}
+
+	payload, err := signaturePayload(imageFullName, digest)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signPayload(key, payload)
+	if err != nil {
+		return err
+	}
+
+	type descriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Size        int               `json:"size"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	var content struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}
+
+	// further signatures are added as layers next to the existing ones
+	existing, err := client.getManifest(repository, signatureTag(digest))
+	if err == nil {
+		err = json.Unmarshal(existing.Body, &content)
+		if err != nil {
+			return fmt.Errorf("Failed to parse signatures of '%s@%s': %s", repository, digest, err.Error())
+		}
+	} else if _, ok := err.(*errorManifestNotFound); !ok {
+		return fmt.Errorf("Failed to fetch signatures of '%s@%s': %s", repository, digest, err.Error())
+	}
+
+	config := []byte("{}")
+	content.SchemaVersion = 2
+	content.MediaType = mediaTypeOCIManifest
+	content.Config = descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Size: len(config), Digest: manifestDigest(config)}
+	content.Layers = append(content.Layers, descriptor{
+		MediaType:   mediaTypeSimpleSigning,
+		Size:        len(payload),
+		Digest:      manifestDigest(payload),
+		Annotations: map[string]string{signatureAnnotation: signature},
+	})
+
+	for _, blob := range [][]byte{config, payload} {
+		err = client.uploadBlob(repository, blob)
+		if err != nil {
+			return fmt.Errorf("Failed to upload signature of '%s@%s': %s", repository, digest, err.Error())
+		}
+	}
+
+	body, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	manifest := &registryManifest{MediaType: mediaTypeOCIManifest, Digest: manifestDigest(body), Body: body}
+	err = client.putManifest(repository, signatureTag(digest), manifest)
+	if err != nil {
+		return fmt.Errorf("Failed to upload signature of '%s@%s': %s", repository, digest, err.Error())
+	}
+
+	return nil
+}
+
+// signAll signs the retagged images, in the target registry as well if they were copied there
+func signAll(ids map[string]imageRef, key *ecdsa.PrivateKey, targetRegistry string) error {
+	for imageFullName, ref := range ids {
+		id := ref.ManifestDigest
+		if id == "" {
+			return fmt.Errorf("Image '%s' has no manifest digest to sign", imageFullName)
+		}
+
+		org, image, token, err := parseImageName(imageFullName)
+		if err != nil {
+			return err
+		}
+
+		client := newRegistryClient(strings.Split(imageFullName, "/")[0], token)
+		err = signImage(client, org+"/"+image, imageFullName, id, key)
+		if err != nil {
+			return err
+		}
+
+		if targetRegistry != "" {
+			mirroredName := mirrorImageName(imageFullName, targetRegistry)
+			parts := strings.SplitN(mirroredName, "/", 2)
+
+			err = signImage(newMirrorClient(parts[0]), parts[1], mirroredName, id, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Printf("Image '%s': signed", imageFullName)
+	}
+
+	return nil
+}
+
+// checkSignable makes sure the images the given tags point to have a manifest digest,
+// so they can be signed once they are retagged
+func checkSignable(images map[string]string) error {
+	ids, err := lookupImageIDs(images)
+	if err != nil {
+		return withExitCode(exitRegistry, err)
+	}
+
+	for imageFullName, tag := range images {
+		ref, ok := ids[imageFullName]
+		if !ok {
+			return withExitCode(exitRegistry, fmt.Errorf("Image '%s' has no tag '%s'", imageFullName, tag))
+		}
+
+		if ref.ManifestDigest == "" {
+			return withExitCode(exitPolicy, fmt.Errorf("Refusing to release image '%s': tag '%s' has no manifest digest that could be signed", imageFullName, tag))
+		}
+	}
+
+	return nil
+}
+
+// checkSignatures makes sure the images the given tags point to are signed by the key
+// and returns their manifest digests
+func checkSignatures(images map[string]string, key *ecdsa.PublicKey) (map[string]string, error) {
+	ids, err := lookupImageIDs(images)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string)
+	for imageFullName, tag := range images {
+		ref, ok := ids[imageFullName]
+		if !ok {
+			return nil, fmt.Errorf("Image '%s' has no tag '%s'", imageFullName, tag)
+		}
+
+		id := ref.ManifestDigest
+		if id == "" {
+			return nil, withExitCode(exitPolicy, fmt.Errorf("Refusing to release image '%s': tag '%s' has no manifest digest a signature could be checked for", imageFullName, tag))
+		}
+
+		org, image, token, err := parseImageName(imageFullName)
+		if err != nil {
+			return nil, err
+		}
+
+		client := newRegistryClient(strings.Split(imageFullName, "/")[0], token)
+		err = verifyImageSignature(client, org+"/"+image, id, key)
+		if err != nil {
+			return nil, withExitCode(exitPolicy, fmt.Errorf("Refusing to release image '%s': %s", imageFullName, err.Error()))
+		}
+
+		log.Printf("Image '%s': signature verified", imageFullName)
+		digests[imageFullName] = id
+	}
+
+	return digests, nil
+}
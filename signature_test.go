@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/experimental-platform/release-tagger/git"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+// writeTestPublicKey writes the PEM encoded public key to a temporary file and returns its path
+func writeTestPublicKey(t *testing.T, key *ecdsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err)
+
+	file, err := ioutil.TempFile("", "release-tagger-key")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	pem.Encode(file, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return file.Name()
+}
+
+func TestCheckSignatures(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	listDigest := manifestDigest([]byte(multiArchList))
+
+	keyPath := writeTestPublicKey(t, &testSigningKey.PublicKey)
+	defer os.Remove(keyPath)
+	key, err := loadVerificationKey(keyPath)
+	assert.Nil(t, err)
+
+	signed := map[string]string{"quay.io/experimentalplatform/multiarch": "development"}
+	ids, err := checkSignatures(signed, key)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"quay.io/experimentalplatform/multiarch": listDigest}, ids)
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = checkSignatures(signed, &otherKey.PublicKey)
+	assert.NotNil(t, err)
+
+	// v1 images have no manifest digest to sign
+	_, err = checkSignatures(map[string]string{"quay.io/experimentalplatform/skvs": "development"}, key)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyPayload(t *testing.T) {
+	listDigest := manifestDigest([]byte(multiArchList))
+	payload, err := signaturePayload("quay.io/experimentalplatform/multiarch", listDigest)
+	assert.Nil(t, err)
+	signature, err := signPayload(testSigningKey, payload)
+	assert.Nil(t, err)
+
+	assert.Nil(t, verifyPayload(payload, signature, listDigest, &testSigningKey.PublicKey))
+	assert.NotNil(t, verifyPayload(payload, signature, manifestDigest([]byte("other")), &testSigningKey.PublicKey), "signed for another digest")
+	assert.NotNil(t, verifyPayload(append(payload, ' '), signature, listDigest, &testSigningKey.PublicKey), "tampered payload")
+	assert.NotNil(t, verifyPayload(payload, "bm90IGEgc2lnbmF0dXJl", listDigest, &testSigningKey.PublicKey))
+}
+
+func TestUpdateJSONRefusesUnsignedImages(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("TOKEN_PROTONET", "foobar token")()
+
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("soul3", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "development",
+		},
+	}})
+	assert.Nil(t, err)
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keyPath := writeTestPublicKey(t, &otherKey.PublicKey)
+	defer os.Remove(keyPath)
+
+	opts := taggerOptions{
+		SignatureKey: keyPath,
+		Args: taggerOptionsArgs{
+			Action:        "create",
+			SourceChannel: "soul3",
+			TargetChannel: "soul3-signed",
+		},
+	}
+	err = updateJSON(repo, opts, "2016-09-01-1200", "2016-09-01T12:00:00Z")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Refusing to release image 'quay.io/experimentalplatform/skvs'")
+	assert.Equal(t, exitPolicy, exitCode(err))
+
+	_, err = repo.LoadChannel("soul3-signed")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUpdateJSONRefusesUnsignableImages(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("TOKEN_PROTONET", "foobar token")()
+
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("soul3", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "development",
+		},
+	}})
+	assert.Nil(t, err)
+
+	der, err := x509.MarshalECPrivateKey(testSigningKey)
+	assert.Nil(t, err)
+	file, err := ioutil.TempFile("", "release-tagger-key")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	file.Close()
+
+	opts := taggerOptions{
+		SigningKey: file.Name(),
+		Args: taggerOptionsArgs{
+			Action:        "create",
+			SourceChannel: "soul3",
+			TargetChannel: "soul3-signed",
+		},
+	}
+
+	// the v1 image has no manifest digest to sign, so no tag is moved
+	err = updateJSON(repo, opts, "2016-09-01-1201", "2016-09-01T12:01:00Z")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Refusing to release image 'quay.io/experimentalplatform/skvs'")
+	assert.Equal(t, exitPolicy, exitCode(err))
+
+	tags, err := getImageTags("skvs", "experimentalplatform", "foobar token")
+	assert.Nil(t, err)
+	assert.Nil(t, findTag(tags, "2016-09-01-1201"))
+
+	_, err = repo.LoadChannel("soul3-signed")
+	assert.True(t, os.IsNotExist(err))
+
+	// the tag named after the source channel is retagged and signed, not the one recorded in it
+	err = repo.SaveChannel("development", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/multiarch": "2016-08-01-1501",
+		},
+	}})
+	assert.Nil(t, err)
+
+	opts.Args.SourceChannel = "development"
+	opts.Args.TargetChannel = "development-signed"
+	err = updateJSON(repo, opts, "2016-09-01-1202", "2016-09-01T12:02:00Z")
+	assert.Nil(t, err)
+}
+
+func TestSignAll(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	defer setTestEnv("MIRROR_USERNAME", "mirror")()
+	defer setTestEnv("MIRROR_PASSWORD", "secret")()
+	listDigest := manifestDigest([]byte(multiArchList))
+	ids := map[string]imageRef{"quay.io/experimentalplatform/multiarch": {ManifestDigest: listDigest}}
+
+	releaseKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	err := signAll(ids, releaseKey, "mirror.example.com/customer")
+	assert.Nil(t, err)
+
+	// the new signature is added next to the one made by CI
+	_, err = checkSignatures(map[string]string{"quay.io/experimentalplatform/multiarch": "development"}, &releaseKey.PublicKey)
+	assert.Nil(t, err)
+	_, err = checkSignatures(map[string]string{"quay.io/experimentalplatform/multiarch": "development"}, &testSigningKey.PublicKey)
+	assert.Nil(t, err)
+
+	err = verifyImageSignature(newMirrorClient("mirror.example.com"), "customer/multiarch", listDigest, &releaseKey.PublicKey)
+	assert.Nil(t, err)
+
+	// promoting the same image again keeps its signatures as they are
+	registryLock.Lock()
+	signatures := registryManifests[signatureTag(listDigest)]
+	registryLock.Unlock()
+
+	err = signAll(map[string]imageRef{"quay.io/experimentalplatform/multiarch": {ManifestDigest: listDigest}}, releaseKey, "")
+	assert.Nil(t, err)
+
+	registryLock.Lock()
+	assert.Equal(t, signatures, registryManifests[signatureTag(listDigest)])
+	registryLock.Unlock()
+
+	// v1 images cannot be signed
+	err = signAll(map[string]imageRef{"quay.io/experimentalplatform/skvs": {DockerImageID: "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb"}}, releaseKey, "")
+	assert.NotNil(t, err)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	der, err := x509.MarshalECPrivateKey(testSigningKey)
+	assert.Nil(t, err)
+
+	file, err := ioutil.TempFile("", "release-tagger-key")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	file.Close()
+
+	key, err := loadSigningKey(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, testSigningKey.D, key.D)
+
+	publicKeyPath := writeTestPublicKey(t, &testSigningKey.PublicKey)
+	defer os.Remove(publicKeyPath)
+	_, err = loadSigningKey(publicKeyPath)
+	assert.NotNil(t, err)
+}
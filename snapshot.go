@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+type snapshotImage struct {
+	Image      string `json:"image"`
+	Tag        string `json:"tag"`
+	ImageID    string `json:"image_id,omitempty"`
+	RecordedID string `json:"recorded_id,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// channelSnapshot is a channel's build data as it was at a given time,
+// along with the image ids its tags pointed to at that time
+type channelSnapshot struct {
+	Channel  string          `json:"channel"`
+	At       time.Time       `json:"at"`
+	CommitID string          `json:"commit"`
+	Date     time.Time       `json:"date"`
+	Build    git.BuildsDatum `json:"build"`
+	Images   []snapshotImage `json:"images"`
+}
+
+// findChannelEntry returns the newest history entry committed at or before the given time
+func findChannelEntry(entries []git.ChannelHistoryEntry, at time.Time) *git.ChannelHistoryEntry {
+	for i, entry := range entries {
+		if !entry.Date.After(at) {
+			return &entries[i]
+		}
+	}
+
+	return nil
+}
+
+// resolveSnapshotImages looks up the image ids the tags of a build pointed to at the given time
+func resolveSnapshotImages(build git.BuildsDatum, at time.Time) []snapshotImage {
+	var images []string
+	for image := range build.Images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	recorded := recordedImageRefs(build)
+
+	results := []snapshotImage{}
+	for _, imageFullName := range images {
+		result := snapshotImage{
+			Image:      imageFullName,
+			Tag:        build.Images[imageFullName],
+			RecordedID: recorded[imageFullName].String(),
+		}
+
+		lines, err := tagTimeline(imageFullName, result.Tag)
+		if err != nil {
+			result.Error = err.Error()
+		} else if line := tagImageAt(lines, at); line != nil {
+			result.ImageID = line.ImageID
+		} else {
+			result.Error = fmt.Sprintf("tag did not exist at %s", at.Format(time.RFC3339))
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func snapshotChannel(repo *git.BuildsRepo, channelName string, at time.Time) (*channelSnapshot, error) {
+	entries, err := repo.ChannelHistory(channelName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read history of channel '%s': %s", channelName, err.Error())
+	}
+
+	entry := findChannelEntry(entries, at)
+	if entry == nil {
+		return nil, fmt.Errorf("Channel '%s' did not exist at %s", channelName, at.Format(time.RFC3339))
+	}
+
+	if len(entry.Builds) == 0 {
+		return nil, fmt.Errorf("Channel '%s' contained no builds at %s", channelName, at.Format(time.RFC3339))
+	}
+
+	return &channelSnapshot{
+		Channel:  channelName,
+		At:       at,
+		CommitID: entry.CommitID,
+		Date:     entry.Date,
+		Build:    entry.Builds[0],
+		Images:   resolveSnapshotImages(entry.Builds[0], at),
+	}, nil
+}
+
+func printSnapshot(repo *git.BuildsRepo, opts taggerOptions) error {
+	var err error
+	at := now().UTC()
+	if opts.At != "" {
+		at, err = parseAtTime(opts.At)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
+	}
+
+	err = checkIfTokensPresent()
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := snapshotChannel(repo, opts.Args.SourceChannel, at)
+	if err != nil {
+		return err
+	}
+
+	if opts.Output == "json" {
+		return printJSON(snapshot)
+	}
+
+	fmt.Printf("Channel:      %s\n", snapshot.Channel)
+	fmt.Printf("At:           %s\n", snapshot.At.Format(time.RFC3339))
+	fmt.Printf("Commit:       %s (%s)\n", snapshot.CommitID, snapshot.Date.UTC().Format(time.RFC3339))
+	fmt.Printf("Build:        %d\n", snapshot.Build.Build)
+	fmt.Printf("Published at: %s\n", snapshot.Build.PublishedAt)
+	fmt.Printf("Codename:     %s\n", snapshot.Build.Codename)
+	fmt.Printf("URL:          %s\n\n", snapshot.Build.URL)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tTAG\tIMAGE ID\tRECORDED ID")
+	for _, image := range snapshot.Images {
+		id := image.ImageID
+		if image.Error != "" {
+			id = fmt.Sprintf("error: %s", image.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", image.Image, image.Tag, id, image.RecordedID)
+	}
+
+	return w.Flush()
+}
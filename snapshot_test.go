@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/experimental-platform/release-tagger/git"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func TestFindChannelEntry(t *testing.T) {
+	entries := []git.ChannelHistoryEntry{
+		{CommitID: "c3", Date: time.Date(2016, 8, 11, 12, 0, 0, 0, time.UTC)},
+		{CommitID: "c2", Date: time.Date(2016, 8, 8, 15, 0, 0, 0, time.UTC)},
+		{CommitID: "c1", Date: time.Date(2016, 7, 19, 13, 0, 0, 0, time.UTC)},
+	}
+
+	entry := findChannelEntry(entries, time.Date(2016, 8, 10, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, "c2", entry.CommitID)
+
+	entry = findChannelEntry(entries, time.Date(2016, 8, 8, 15, 0, 0, 0, time.UTC))
+	assert.Equal(t, "c2", entry.CommitID)
+
+	assert.Nil(t, findChannelEntry(entries, time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestResolveSnapshotImages(t *testing.T) {
+	build := git.BuildsDatum{
+		Build: 3,
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "soul3",
+			"quay.io/unknown/image":             "soul3",
+		},
+		ImageIDs: map[string]string{
+			"quay.io/experimentalplatform/skvs": "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc",
+		},
+	}
+
+	images := resolveSnapshotImages(build, time.Date(2016, 8, 8, 15, 6, 0, 0, time.UTC))
+	assert.Len(t, images, 2)
+	assert.Equal(t, "quay.io/experimentalplatform/skvs", images[0].Image)
+	assert.Equal(t, "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc", images[0].ImageID)
+	assert.Equal(t, images[0].RecordedID, images[0].ImageID)
+	assert.Equal(t, "", images[0].Error)
+	assert.NotEqual(t, "", images[1].Error)
+
+	// before the tag was created
+	images = resolveSnapshotImages(build, time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, "", images[0].ImageID)
+	assert.Contains(t, images[0].Error, "did not exist")
+}
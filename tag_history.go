@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// tagHistoryLine is one image a tag pointed to, from Start until End, or until now if End is nil
+type tagHistoryLine struct {
+	ImageID   string     `json:"image_id"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"`
+	Reversion bool       `json:"reversion"`
+}
+
+// atTimeLayouts are the accepted formats of --at, the short ones are taken as UTC
+var atTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+func parseAtTime(value string) (time.Time, error) {
+	for _, layout := range atTimeLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid time '%s', expected e.g. '2016-08-10T12:00:00Z'", value)
+}
+
+// tagTimeline returns the images a tag pointed to, oldest first
+func tagTimeline(imageFullName, tag string) ([]tagHistoryLine, error) {
+	org, image, token, err := parseImageName(imageFullName)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := getTagHistory(image, org, tag, token)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []tagHistoryLine{}
+	for _, entry := range history {
+		line := tagHistoryLine{
+			ImageID:   tagImageID(&entry),
+			Reversion: entry.Reversion,
+		}
+
+		if entry.StartTs != nil {
+			line.Start = time.Unix(int64(*entry.StartTs), 0).UTC()
+		}
+		if entry.EndTs != nil {
+			end := time.Unix(int64(*entry.EndTs), 0).UTC()
+			line.End = &end
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// tagImageAt returns the timeline entry in effect at the given time, or nil if the tag did not exist then
+func tagImageAt(lines []tagHistoryLine, at time.Time) *tagHistoryLine {
+	var found *tagHistoryLine
+	for i, line := range lines {
+		if !line.Start.After(at) && (line.End == nil || at.Before(*line.End)) {
+			found = &lines[i]
+		}
+	}
+
+	return found
+}
+
+func printTagHistory(opts taggerOptions) error {
+	imageFullName, tag := opts.Args.SourceChannel, opts.Args.TargetChannel
+
+	lines, err := tagTimeline(imageFullName, tag)
+	if err != nil {
+		return withExitCode(exitRegistry, fmt.Errorf("Failed to read history of tag '%s' of image '%s': %s", tag, imageFullName, err.Error()))
+	}
+
+	if opts.At != "" {
+		at, err := parseAtTime(opts.At)
+		if err != nil {
+			return withExitCode(exitUsage, err)
+		}
+
+		line := tagImageAt(lines, at)
+		if line == nil {
+			return fmt.Errorf("Tag '%s' of image '%s' did not exist at %s", tag, imageFullName, at.Format(time.RFC3339))
+		}
+
+		if opts.Output == "json" {
+			return printJSON(line)
+		}
+
+		fmt.Println(line.ImageID)
+		return nil
+	}
+
+	if opts.Output == "json" {
+		return printJSON(lines)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE ID\tFROM\tUNTIL")
+	for _, line := range lines {
+		until := "now"
+		if line.End != nil {
+			until = line.End.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", line.ImageID, line.Start.Format(time.RFC3339), until)
+	}
+
+	return w.Flush()
+}
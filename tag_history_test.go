@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func TestTagTimeline(t *testing.T) {
+	lines, err := tagTimeline("quay.io/experimentalplatform/skvs", "soul3")
+	assert.Nil(t, err)
+	assert.Len(t, lines, 3)
+	assert.Equal(t, time.Unix(1468933123, 0).UTC(), lines[0].Start)
+	assert.Equal(t, time.Unix(1470668730, 0).UTC(), *lines[0].End)
+	assert.Nil(t, lines[2].End)
+
+	at, _ := parseAtTime("2016-08-08T15:06Z")
+	line := tagImageAt(lines, at)
+	assert.NotNil(t, line)
+	assert.Equal(t, "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc", line.ImageID)
+
+	// the tag moves away at its end time
+	line = tagImageAt(lines, time.Unix(1470668730, 0))
+	assert.Equal(t, "1319cc8604ca0cf46cd559632db880594766a1737768ff280e767777eada73bc", line.ImageID)
+
+	line = tagImageAt(lines, time.Now())
+	assert.Equal(t, "fb38f27d3c97e1d23b07100b9cfefe4e91ee5c5373f192ba5033cf31fdbf40fb", line.ImageID)
+
+	assert.Nil(t, tagImageAt(lines, time.Unix(1468933122, 0)))
+}
+
+func TestParseAtTime(t *testing.T) {
+	expected := time.Date(2016, 8, 10, 12, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2016-08-10T12:00:00Z", "2016-08-10T12:00Z", "2016-08-10T14:00+02:00", "2016-08-10T12:00"} {
+		at, err := parseAtTime(value)
+		assert.Nil(t, err, value)
+		assert.True(t, expected.Equal(at), value)
+	}
+
+	_, err := parseAtTime("last tuesday")
+	assert.NotNil(t, err)
+}
@@ -4,40 +4,104 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 )
 
-func retagImage(imageFullName, sourceTag, targetTag string) error {
+// parseImageName splits a full image name like 'quay.io/experimentalplatform/skvs'
+// and looks up the Quay token for its organization
+func parseImageName(imageFullName string) (org, image, token string, err error) {
 	imageNameParts := strings.Split(imageFullName, "/")
 	if len(imageNameParts) != 3 {
-		return fmt.Errorf("Incorrect image full name '%s'", imageFullName)
+		return "", "", "", fmt.Errorf("Incorrect image full name '%s'", imageFullName)
 	}
 
-	// registry := imageNameParts[0]
-	org := imageNameParts[1]
-	image := imageNameParts[2]
+	if imageNameParts[0] != "quay.io" {
+		return "", "", "", fmt.Errorf("Image '%s' is not hosted on Quay", imageFullName)
+	}
+
+	org = imageNameParts[1]
+	image = imageNameParts[2]
 
-	var token string
 	if org == "experimentalplatform" {
 		token = os.Getenv("TOKEN_PLATFORM")
 	} else if org == "protonetinc" {
 		token = os.Getenv("TOKEN_PROTONET")
 	} else {
-		return fmt.Errorf("Unknown image org '%s'", org)
+		return "", "", "", fmt.Errorf("Unknown image org '%s'", org)
+	}
+
+	return org, image, token, nil
+}
+
+// retagImage points targetTag to the image sourceTag points to and returns that image's ids.
+// If verified is given, targetTag points to the manifest of that digest instead.
+func retagImage(imageFullName, sourceTag, verified, targetTag string) (imageRef, error) {
+	org, image, token, err := parseImageName(imageFullName)
+	if err != nil {
+		return imageRef{}, err
 	}
 
 	id, err := getTagImage(image, org, sourceTag, token)
 	if err != nil {
-		return err
+		return imageRef{}, err
+	}
+
+	// the source tag may have moved on since its signature was verified
+	if verified != "" && id.ManifestDigest != verified {
+		log.Printf("Image '%s': tag '%s' points to '%s' now, retagging the verified '%s'", imageFullName, sourceTag, id, verified)
+		id = imageRef{ManifestDigest: verified}
+	}
+
+	// v2 images are retagged by manifest digest, keeping all platforms of multi-arch images
+	if id.ManifestDigest != "" {
+		registry := strings.Split(imageFullName, "/")[0]
+		client := newRegistryClient(registry, token)
+
+		mediaType, err := client.manifestMediaType(org+"/"+image, id.ManifestDigest)
+		if err != nil {
+			return imageRef{}, fmt.Errorf("Failed to fetch manifest '%s' of '%s': %s", id.ManifestDigest, imageFullName, err.Error())
+		}
+
+		if supportedManifestType(mediaType) {
+			return id, client.tagManifest(org+"/"+image, id.ManifestDigest, targetTag)
+		}
 	}
 
-	return setTagImage(image, org, targetTag, id, token)
+	// v1 images and legacy schema1 manifests are retagged through the Quay API
+	if id.DockerImageID == "" {
+		return imageRef{}, fmt.Errorf("Image '%s' has neither a supported manifest nor a docker image id", imageFullName)
+	}
+
+	return id, setTagImage(image, org, targetTag, id.DockerImageID, token)
 }
 
-func retagAll(images map[string]string, sourceTag, targetTag string) error {
+// retagErrors holds the images which could not be retagged
+type retagErrors map[string]error
+
+func (e retagErrors) Error() string {
+	var images []string
+	for image := range e {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	var messages []string
+	for _, image := range images {
+		messages = append(messages, fmt.Sprintf("Failed to retag image '%s': %s", image, e[image].Error()))
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// retagAll retags all images and returns the ids of the images now tagged with targetTag.
+// Images with a verified manifest digest are retagged by that digest. If some images fail,
+// the ids of the others are returned along with a retagErrors.
+func retagAll(images map[string]string, sourceTag string, verified map[string]string, targetTag string) (map[string]imageRef, error) {
 	type response struct {
-		Image string
-		Error error
+		Image   string
+		ImageID imageRef
+		Error   error
 	}
 
 	count := len(images)
@@ -46,18 +110,94 @@ func retagAll(images map[string]string, sourceTag, targetTag string) error {
 	for k := range images {
 		imageFullName := k
 		go func() {
-			err := retagImage(imageFullName, sourceTag, targetTag)
-			channel <- response{Image: imageFullName, Error: err}
+			id, err := retagImage(imageFullName, sourceTag, verified[imageFullName], targetTag)
+			channel <- response{Image: imageFullName, ImageID: id, Error: err}
 		}()
 	}
 
+	ids := make(map[string]imageRef)
+	failed := make(retagErrors)
 	for i := 0; i < count; i++ {
 		resp := <-channel
 		if resp.Error == nil {
 			log.Printf("Image '%s': SUCCESS", resp.Image)
+			ids[resp.Image] = resp.ImageID
 		} else {
 			log.Printf("Image '%s': ERROR: %s", resp.Image, resp.Error.Error())
-			return resp.Error
+			failed[resp.Image] = resp.Error
+		}
+	}
+
+	if len(failed) > 0 {
+		return ids, failed
+	}
+
+	return ids, nil
+}
+
+// lookupImageIDs returns the ids of the images the given tags point to,
+// images whose tag does not exist are left out
+func lookupImageIDs(images map[string]string) (map[string]imageRef, error) {
+	type response struct {
+		Image   string
+		ImageID imageRef
+		Error   error
+	}
+
+	count := len(images)
+	channel := make(chan response)
+
+	for k, v := range images {
+		imageFullName, tag := k, v
+		go func() {
+			org, image, token, err := parseImageName(imageFullName)
+			if err != nil {
+				channel <- response{Image: imageFullName, Error: err}
+				return
+			}
+
+			id, err := getTagImage(image, org, tag, token)
+			channel <- response{Image: imageFullName, ImageID: id, Error: err}
+		}()
+	}
+
+	ids := make(map[string]imageRef)
+	var err error
+	for i := 0; i < count; i++ {
+		resp := <-channel
+		if _, ok := resp.Error.(*errorQuayTagNotFound); ok {
+			continue
+		}
+
+		if resp.Error != nil {
+			err = resp.Error
+		} else {
+			ids[resp.Image] = resp.ImageID
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// checkTagCollisions makes sure none of the images has or ever had the tag
+func checkTagCollisions(images map[string]string, tag string) error {
+	for imageFullName := range images {
+		org, image, token, err := parseImageName(imageFullName)
+		if err != nil {
+			return err
+		}
+
+		tags, err := getImageTags(image, org, token)
+		if err != nil {
+			return err
+		}
+
+		if findTag(tags, tag) != nil {
+			return fmt.Errorf("Tag '%s' already exists for image '%s'", tag, imageFullName)
 		}
 	}
 
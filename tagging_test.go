@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func TestRetagAll(t *testing.T) {
+	images := map[string]string{"quay.io/experimentalplatform/skvs": "2016-08-11-1153"}
+	ids, err := retagAll(images, "development", nil, "foobar")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]imageRef{"quay.io/experimentalplatform/skvs": {DockerImageID: "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f"}}, ids)
+}
+
+func TestRetagAllPartialFailure(t *testing.T) {
+	images := map[string]string{
+		"quay.io/experimentalplatform/skvs":          "2016-08-11-1153",
+		"quay.io/experimentalplatform/no-such-image": "development",
+	}
+	ids, err := retagAll(images, "development", nil, "foobar")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Failed to retag image 'quay.io/experimentalplatform/no-such-image'")
+	assert.Equal(t, map[string]imageRef{"quay.io/experimentalplatform/skvs": {DockerImageID: "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f"}}, ids)
+
+	result := &releaseResult{}
+	result.addRetagResults(images, "foobar", ids, err)
+	sort.Sort(imageResultsByName(result.Images))
+	assert.Len(t, result.Images, 2)
+	assert.Equal(t, imageFailed, result.Images[0].Status)
+	assert.NotEmpty(t, result.Images[0].Error)
+	assert.Equal(t, imageRetagged, result.Images[1].Status)
+	assert.Equal(t, "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", result.Images[1].ID)
+}
+
+func TestCheckTagCollisions(t *testing.T) {
+	images := map[string]string{"quay.io/experimentalplatform/skvs": "development"}
+
+	assert.Nil(t, checkTagCollisions(images, "2016-09-01-1200"))
+	assert.NotNil(t, checkTagCollisions(images, "2016-08-11-1153"))
+}
+
+func TestRetagManifestList(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+	listDigest := manifestDigest([]byte(multiArchList))
+	listRef := imageRef{DockerImageID: "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", ManifestDigest: listDigest}
+
+	images := map[string]string{"quay.io/experimentalplatform/multiarch": "development"}
+	ids, err := retagAll(images, "development", nil, "2016-09-01-1200")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]imageRef{"quay.io/experimentalplatform/multiarch": listRef}, ids)
+
+	// the list itself was tagged, not one of its platform manifests
+	registryTags.Lock()
+	assert.Equal(t, listDigest, registryTags.m["2016-09-01-1200"])
+	registryTags.Unlock()
+
+	ids, err = lookupImageIDs(images)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]imageRef{"quay.io/experimentalplatform/multiarch": listRef}, ids)
+}
+
+func TestRetagVerifiedDigest(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+
+	var list struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(multiArchList), &list))
+	verified := list.Manifests[0].Digest
+
+	// the tag points to the list, but the amd64 manifest was verified
+	images := map[string]string{"quay.io/experimentalplatform/multiarch": "development"}
+	ids, err := retagAll(images, "development", map[string]string{"quay.io/experimentalplatform/multiarch": verified}, "2016-09-01-1300")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]imageRef{"quay.io/experimentalplatform/multiarch": {ManifestDigest: verified}}, ids)
+
+	registryTags.Lock()
+	assert.Equal(t, verified, registryTags.m["2016-09-01-1300"])
+	registryTags.Unlock()
+}
+
+func TestRetagSchema1Image(t *testing.T) {
+	defer setTestEnv("TOKEN_PLATFORM", "foobar token")()
+
+	// the legacy manifest is retagged through the Quay API by its docker image id
+	images := map[string]string{"quay.io/experimentalplatform/legacy": "development"}
+	ids, err := retagAll(images, "development", nil, "2016-09-01-1200")
+	assert.Nil(t, err)
+	assert.Equal(t, legacyImageID, ids["quay.io/experimentalplatform/legacy"].DockerImageID)
+
+	legacyTags.Lock()
+	assert.Equal(t, legacyImageID, legacyTags.m["2016-09-01-1200"])
+	legacyTags.Unlock()
+}
+
+func TestParseImageNameOtherRegistry(t *testing.T) {
+	_, _, _, err := parseImageName("mirror.example.com/experimentalplatform/skvs")
+	assert.NotNil(t, err)
+
+	org, image, _, err := parseImageName("quay.io/experimentalplatform/skvs")
+	assert.Nil(t, err)
+	assert.Equal(t, "experimentalplatform", org)
+	assert.Equal(t, "skvs", image)
+}
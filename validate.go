@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+type channelValidation struct {
+	Channel string   `json:"channel"`
+	Valid   bool     `json:"valid"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func checkChannels(repo *git.BuildsRepo) ([]channelValidation, error) {
+	channels, err := repo.ListChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []channelValidation{}
+	for _, channel := range channels {
+		result := channelValidation{Channel: channel, Valid: true}
+
+		_, err := repo.LoadChannel(channel)
+		if validationErr, ok := err.(*git.ValidationError); ok {
+			result.Valid = false
+			result.Errors = validationErr.Problems
+		} else if err != nil {
+			result.Valid = false
+			result.Errors = []string{err.Error()}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func validateChannels(repo *git.BuildsRepo, opts taggerOptions) error {
+	results, err := checkChannels(repo)
+	if err != nil {
+		return err
+	}
+
+	return printValidation(results, opts)
+}
+
+func printValidation(results []channelValidation, opts taggerOptions) error {
+	if opts.Output == "json" {
+		err := printJSON(results)
+		if err != nil {
+			return err
+		}
+	}
+
+	invalid := 0
+	for _, result := range results {
+		if !result.Valid {
+			invalid++
+		}
+
+		if opts.Output == "text" {
+			if result.Valid {
+				fmt.Printf("%s: OK\n", result.Channel)
+			} else {
+				fmt.Printf("%s: INVALID\n", result.Channel)
+				for _, problem := range result.Errors {
+					fmt.Printf(" * %s\n", problem)
+				}
+			}
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d channels are invalid", invalid, len(results))
+	}
+
+	return nil
+}
+
+func printSchema() error {
+	rawData, err := json.MarshalIndent(git.BuildsSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(rawData))
+	return nil
+}
+
+func checkChannelsAgainstSchema(repo *git.BuildsRepo, channels []string) ([]channelValidation, error) {
+	schema := git.BuildsSchema()
+
+	results := []channelValidation{}
+	for _, channel := range channels {
+		rawData, err := repo.DumpChannel(channel)
+		if err != nil {
+			return nil, err
+		}
+
+		problems, err := schema.ValidateJSON([]byte(rawData))
+		if err != nil {
+			problems = []string{err.Error()}
+		}
+
+		results = append(results, channelValidation{Channel: channel, Valid: len(problems) == 0, Errors: problems})
+	}
+
+	return results, nil
+}
+
+func validateChannelsAgainstSchema(repo *git.BuildsRepo, opts taggerOptions) error {
+	results, err := checkChannelsAgainstSchema(repo, opts.Args.operands())
+	if err != nil {
+		return err
+	}
+
+	return printValidation(results, opts)
+}
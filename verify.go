@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/experimental-platform/release-tagger/git"
+)
+
+const (
+	// the tag points to the recorded image
+	verifyOK = "ok"
+	// the tag points to a different image than the recorded one
+	verifyChanged = "changed"
+	// the tag never existed
+	verifyMissing = "missing"
+	// the tag existed but was deleted or moved away
+	verifyExpired = "expired"
+	// the tag exists, but no image id was recorded to compare with
+	verifyUnrecorded = "unrecorded"
+	verifyError      = "error"
+)
+
+type imageVerification struct {
+	Image      string `json:"image"`
+	Tag        string `json:"tag"`
+	RecordedID string `json:"recorded_id,omitempty"`
+	CurrentID  string `json:"current_id,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// verifyChannel checks whether the tags of a channel still exist and still point
+// to the image ids recorded on release
+func verifyChannel(repo *git.BuildsRepo, channelName string) ([]imageVerification, error) {
+	builds, err := repo.LoadChannel(channelName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load build data from channel '%s': %s", channelName, err.Error())
+	}
+
+	var images []string
+	for image := range builds[0].Images {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	recordedIDs := recordedImageRefs(builds[0])
+
+	results := []imageVerification{}
+	for _, imageFullName := range images {
+		recorded := recordedIDs[imageFullName]
+		result := imageVerification{
+			Image:      imageFullName,
+			Tag:        builds[0].Images[imageFullName],
+			RecordedID: recorded.String(),
+		}
+
+		// images copied to another registry are looked up there
+		if strings.Split(imageFullName, "/")[0] != "quay.io" {
+			results = append(results, verifyMirroredImage(result, recorded))
+			continue
+		}
+
+		var tag *quayTagsResponseTag
+
+		org, image, token, err := parseImageName(imageFullName)
+		if err == nil {
+			var tags []quayTagsResponseTag
+			tags, err = getImageTags(image, org, token)
+			tag = findTag(tags, result.Tag)
+		}
+
+		var current imageRef
+		if tag != nil && tag.EndTs == nil {
+			current = tagImageRef(tag)
+			// shown in the form that was recorded
+			result.CurrentID = current.String()
+			if recorded.ManifestDigest == "" && recorded.DockerImageID != "" {
+				result.CurrentID = current.DockerImageID
+			}
+		}
+
+		switch {
+		case err != nil:
+			result.Status = verifyError
+			result.Error = err.Error()
+		case tag == nil:
+			result.Status = verifyMissing
+		case tag.EndTs != nil:
+			result.Status = verifyExpired
+		case recorded.empty():
+			result.Status = verifyUnrecorded
+		case !recorded.same(current):
+			result.Status = verifyChanged
+		default:
+			result.Status = verifyOK
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// verifyMirroredImage compares the recorded manifest digest with the one in the image's registry
+func verifyMirroredImage(result imageVerification, recorded imageRef) imageVerification {
+	parts := strings.SplitN(result.Image, "/", 2)
+	client := newMirrorClient(parts[0])
+
+	manifest, err := client.getManifest(parts[1], result.Tag)
+	if err == nil {
+		result.CurrentID = manifest.Digest
+	}
+
+	switch {
+	case err != nil:
+		result.Status = verifyError
+		result.Error = err.Error()
+	case recorded.ManifestDigest == "":
+		result.Status = verifyUnrecorded
+	case recorded.ManifestDigest != result.CurrentID:
+		result.Status = verifyChanged
+	default:
+		result.Status = verifyOK
+	}
+
+	return result
+}
+
+func printVerification(repo *git.BuildsRepo, opts taggerOptions) error {
+	err := checkIfTokensPresent()
+	if err != nil {
+		return err
+	}
+
+	results, err := verifyChannel(repo, opts.Args.SourceChannel)
+	if err != nil {
+		return err
+	}
+
+	if opts.Output == "json" {
+		err = printJSON(results)
+		if err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "IMAGE\tTAG\tSTATUS\tRECORDED ID\tCURRENT ID")
+		for _, result := range results {
+			status := result.Status
+			if result.Error != "" {
+				status = fmt.Sprintf("%s: %s", status, result.Error)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Image, result.Tag, status, result.RecordedID, result.CurrentID)
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Status != verifyOK && result.Status != verifyUnrecorded {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images of channel '%s' failed verification", failed, len(results), opts.Args.SourceChannel)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/experimental-platform/release-tagger/git"
+
+	"gopkg.in/stretchr/testify.v1/assert"
+)
+
+func TestVerifyChannel(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("verified", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "2016-08-11-1153",
+		},
+		ImageIDs: map[string]string{
+			"quay.io/experimentalplatform/skvs": "9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623",
+		},
+	}})
+	assert.Nil(t, err)
+
+	results, err := verifyChannel(repo, "verified")
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, verifyOK, results[0].Status)
+
+	err = repo.SaveChannel("changed", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "development",
+		},
+		ImageIDs: map[string]string{
+			"quay.io/experimentalplatform/skvs": "9aaca8087e1679dc87c5af02e0f31d451833f73346b0bc99c26d0aef0cb33623",
+		},
+	}})
+	assert.Nil(t, err)
+
+	results, err = verifyChannel(repo, "changed")
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, verifyChanged, results[0].Status)
+	assert.Equal(t, "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f", results[0].CurrentID)
+}
+
+// TestVerifyChannelDockerImageIDs tests whether channels recording docker image ids
+// still verify for tags which have a manifest digest as well
+func TestVerifyChannelDockerImageIDs(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("recorded-ids", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/multiarch": "development",
+		},
+		ImageIDs: map[string]string{
+			"quay.io/experimentalplatform/multiarch": "8b8cd46eeab0b530d0fcb64de26fe62fd68e736fc627c526933b680d3ae5291f",
+		},
+	}})
+	assert.Nil(t, err)
+
+	results, err := verifyChannel(repo, "recorded-ids")
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, verifyOK, results[0].Status)
+	assert.Equal(t, results[0].RecordedID, results[0].CurrentID)
+}
+
+func TestVerifyChannelDrift(t *testing.T) {
+	repo, err := git.PrepareRepo("libgit")
+	assert.Nil(t, err)
+	defer repo.Close()
+
+	err = repo.SaveChannel("drifted", git.BuildsData{{
+		Build:       1,
+		PublishedAt: "2016-09-01T12:00:00Z",
+		Images: map[string]string{
+			"quay.io/experimentalplatform/skvs": "kd-cache2",
+		},
+	}})
+	assert.Nil(t, err)
+
+	results, err := verifyChannel(repo, "drifted")
+	assert.Nil(t, err)
+	assert.Equal(t, verifyUnrecorded, results[0].Status)
+	assert.Equal(t, "7e232f4cb858dff3b222b9a01928acb06edca1842be8c0de38749326c6b27dae", results[0].CurrentID)
+
+	builds, err := repo.LoadChannel("drifted")
+	assert.Nil(t, err)
+	builds[0].Images["quay.io/experimentalplatform/skvs"] = "no-such-tag"
+	err = repo.SaveChannel("drifted", builds)
+	assert.Nil(t, err)
+
+	results, err = verifyChannel(repo, "drifted")
+	assert.Nil(t, err)
+	assert.Equal(t, verifyMissing, results[0].Status)
+}
+
+func TestVerifyMirroredImage(t *testing.T) {
+	defer setTestEnv("MIRROR_USERNAME", "mirror")()
+	defer setTestEnv("MIRROR_PASSWORD", "secret")()
+	listDigest := manifestDigest([]byte(multiArchList))
+
+	mirrorRegistry.Lock()
+	mirrorRegistry.manifests[listDigest] = multiArchList
+	mirrorRegistry.tags["verified"] = listDigest
+	mirrorRegistry.Unlock()
+
+	result := verifyMirroredImage(imageVerification{Image: "mirror.example.com/customer/multiarch", Tag: "verified", RecordedID: listDigest}, imageRef{ManifestDigest: listDigest})
+	assert.Equal(t, verifyOK, result.Status)
+
+	result = verifyMirroredImage(imageVerification{Image: "mirror.example.com/customer/multiarch", Tag: "no-such-tag", RecordedID: listDigest}, imageRef{ManifestDigest: listDigest})
+	assert.Equal(t, verifyError, result.Status)
+}